@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cachePath is path of result cache resource, DELETE purges cache
+	cachePath   = "/cache"
+	cacheHeader = "X-Cache"
+)
+
+// results caches model function results, nil if caching is disabled
+var results *resultCache
+
+// resultCache is LRU cache of function results expiring after ttl
+type resultCache struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	bundle  map[string]interface{}
+	expires time.Time
+}
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{
+		size:  size,
+		ttl:   ttl,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// Get returns cached result bundle
+func (rc *resultCache) Get(key string) (map[string]interface{}, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	el, ok := rc.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if rc.ttl > 0 && time.Now().After(entry.expires) {
+		rc.remove(el)
+		return nil, false
+	}
+	rc.lru.MoveToFront(el)
+	return entry.bundle, true
+}
+
+// Add caches result bundle evicting least recently used results
+func (rc *resultCache) Add(key string, bundle map[string]interface{}) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if el, ok := rc.items[key]; ok {
+		rc.remove(el)
+	}
+	entry := &cacheEntry{key: key, bundle: bundle, expires: time.Now().Add(rc.ttl)}
+	rc.items[key] = rc.lru.PushFront(entry)
+	for rc.lru.Len() > rc.size {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// Purge removes all results and returns their number
+func (rc *resultCache) Purge() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	n := rc.lru.Len()
+	rc.lru.Init()
+	rc.items = map[string]*list.Element{}
+	return n
+}
+
+func (rc *resultCache) remove(el *list.Element) {
+	rc.lru.Remove(el)
+	delete(rc.items, el.Value.(*cacheEntry).key)
+}
+
+// cacheKey returns key of model function call with data.
+// Data is canonicalized, so formatting and order of keys don't matter.
+func cacheKey(m *model, data json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(m.Name), []byte(m.Version), []byte(m.Function), canonical} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachedRun runs code unless result of the same request is cached.
+// Results failing model output validation are never cached.
+// Results are cached if cache is enabled and output is not captured,
+// Cache-Control: no-cache request header forces execution.
+func cachedRun(ctx context.Context, w http.ResponseWriter, r *http.Request, m *model, requestData *Request, code string, capture bool) (*result, time.Duration, error) {
+	if results == nil || capture {
+		return m.run(ctx, code)
+	}
+	key, err := cacheKey(m, requestData.Data)
+	if err != nil {
+		return m.run(ctx, code)
+	}
+	if !strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+		if bundle, ok := results.Get(key); ok {
+			metrics.Add("cache_hits", 1)
+			w.Header().Set(cacheHeader, "HIT")
+			return &result{bundle: bundle}, 0, nil
+		}
+	}
+	metrics.Add("cache_misses", 1)
+	w.Header().Set(cacheHeader, "MISS")
+	res, duration, err := m.run(ctx, code)
+	if err == nil {
+		results.Add(key, res.bundle)
+	}
+	return res, duration, err
+}
+
+// CacheHandler purges result cache on DELETE
+func CacheHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	if r.Method != http.MethodDelete {
+		appErr := AppError{StatusCode: http.StatusMethodNotAllowed}
+		appErr.Write(ctx, w)
+		return
+	}
+	if !checkToken(args.ApiRoot, r.URL.Query().Get("access_token")) {
+		appErr := AppError{StatusCode: http.StatusForbidden}
+		appErr.Write(ctx, w)
+		return
+	}
+	purged := 0
+	if results != nil {
+		purged = results.Purge()
+	}
+	writeJSON(w, map[string]int{"purged": purged})
+}
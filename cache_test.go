@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestResultCache(t *testing.T) {
+	rc := newResultCache(2, time.Minute)
+	rc.Add("a", map[string]interface{}{"text/plain": "1"})
+	rc.Add("b", map[string]interface{}{"text/plain": "2"})
+	rc.Get("a")
+	rc.Add("c", map[string]interface{}{"text/plain": "3"})
+	if _, ok := rc.Get("b"); ok {
+		t.Error("Least recently used result is not evicted")
+	}
+	if bundle, ok := rc.Get("a"); !ok || bundle["text/plain"] != "1" {
+		t.Error("Recently used result is evicted")
+	}
+	if n := rc.Purge(); n != 2 {
+		t.Errorf("Wrong number of purged results: %d", n)
+	}
+	if _, ok := rc.Get("c"); ok {
+		t.Error("Result is not purged")
+	}
+	rc = newResultCache(2, time.Millisecond)
+	rc.Add("a", nil)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := rc.Get("a"); ok {
+		t.Error("Result is not expired")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	m := &model{Name: "model", Version: "1.0", Function: "predict"}
+	key1, err := cacheKey(m, json.RawMessage(`{"a": 1, "b": [1, 2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, _ := cacheKey(m, json.RawMessage(`{"b":[1,2],"a":1}`))
+	if key1 != key2 {
+		t.Error("Equal data have different keys")
+	}
+	key3, _ := cacheKey(&model{Name: "model", Version: "2.0", Function: "predict"}, json.RawMessage(`{"a": 1, "b": [1, 2]}`))
+	if key1 == key3 {
+		t.Error("Model versions have equal keys")
+	}
+}
+
+func TestCachedRun(t *testing.T) {
+	calls := 0
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		calls++
+		websocket.JSON.Send(ws, executeResult(req, "'result'"))
+		finish(ws, req)
+	})
+	defer ts.Close()
+	defer s.Close()
+	results = newResultCache(10, time.Minute)
+	defer func() { results = nil }()
+	p, restore := withTestPool(s)
+	defer restore()
+	m := &model{Version: "1.0", Function: "test", pool: p}
+	requestData := &Request{Data: json.RawMessage(`{"a": 1}`)}
+	for _, c := range []struct {
+		cacheControl string
+		expected     string
+	}{{"", "MISS"}, {"", "HIT"}, {"no-cache", "MISS"}} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Cache-Control", c.cacheControl)
+		res, _, err := cachedRun(context.Background(), w, r, m, requestData, "test()", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.text() != "'result'" || w.Header().Get(cacheHeader) != c.expected {
+			t.Errorf("Wrong cached result %s: %s", w.Header().Get(cacheHeader), res.text())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Wrong number of kernel executions: %d", calls)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// overlap policies of scheduled runs
+const (
+	overlapSkip  = "skip"
+	overlapQueue = "queue"
+)
+
+// cronDescriptors are shortcuts of cron expressions
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// schedule is parsed 5 field cron expression:
+// minute, hour, day of month, month and day of week.
+// Every field is a bit set of matching values.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// day matches if either dom or dow matches unless one of them is *
+	domStar, dowStar bool
+}
+
+// parseSchedule parses cron expression like "*/15 9-17 * * 1-5"
+func parseSchedule(expr string) (*schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[expr]; ok {
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Cron expression %q should have 5 fields", expr)
+	}
+	s := &schedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	bounds := []struct {
+		field    *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		*b.field, err = parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("Cron expression %q: %s", expr, err)
+		}
+	}
+	// 7 is sunday as well as 0
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField parses comma separated list of values, ranges and steps
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			start, end = value, value
+			if step > 1 {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns first time matching schedule after t. Schedule is matched
+// against wall clock of t location, so it follows zone offset and DST changes.
+func (s *schedule) next(t time.Time) time.Time {
+	t = wallDate(t, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+	// impossible schedules like 30th of February never match
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = wallDate(t, t.Year(), t.Month()+1, 1, 0, 0)
+		case !s.matchDay(t):
+			t = wallDate(t, t.Year(), t.Month(), t.Day()+1, 0, 0)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = wallDate(t, t.Year(), t.Month(), t.Day(), t.Hour()+1, 0)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = wallDate(t, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// wallDate returns later wall clock time in t location. Wall clock time
+// skipped or repeated on DST change may resolve before t, then t is
+// advanced by wall clock distance to requested time instead.
+func wallDate(t time.Time, year int, month time.Month, day, hour, min int) time.Time {
+	next := time.Date(year, month, day, hour, min, 0, 0, t.Location())
+	if next.After(t) {
+		return next
+	}
+	wall := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	now := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return t.Add(wall.Sub(now))
+}
+
+// scheduler runs function on schedule. Runs never overlap, run due while
+// previous one is still running is skipped or queued depending on overlap.
+type scheduler struct {
+	schedule *schedule
+	overlap  string
+	run      func(ctx context.Context)
+
+	runs chan time.Time
+	mu   sync.Mutex
+	// running is true while run is running or queued
+	running bool
+	next    time.Time
+}
+
+func newScheduler(expr, overlap string, run func(ctx context.Context)) (*scheduler, error) {
+	s, err := parseSchedule(expr)
+	if err != nil {
+		return nil, err
+	}
+	if overlap != overlapSkip && overlap != overlapQueue {
+		return nil, fmt.Errorf("Unknown overlap policy %q, use %s or %s", overlap, overlapSkip, overlapQueue)
+	}
+	return &scheduler{schedule: s, overlap: overlap, run: run, runs: make(chan time.Time, 1)}, nil
+}
+
+// Run triggers runs on schedule until ctx is done
+func (sc *scheduler) Run(ctx context.Context) error {
+	go sc.work(ctx)
+	for {
+		next := sc.schedule.next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("Schedule never matches")
+		}
+		log.Printf("Next run at %s", next.Format(time.RFC3339))
+		sc.mu.Lock()
+		sc.next = next
+		sc.mu.Unlock()
+		select {
+		case <-time.After(time.Until(next)):
+			sc.trigger(next)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// trigger requests run unless it is skipped by overlap policy.
+// At most one run is queued.
+func (sc *scheduler) trigger(t time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.running && sc.overlap == overlapSkip {
+		log.Printf("Run at %s skipped, previous run is still running", t.Format(time.RFC3339))
+		return
+	}
+	select {
+	case sc.runs <- t:
+		sc.running = true
+	default:
+		log.Printf("Run at %s skipped, previous run is already queued", t.Format(time.RFC3339))
+	}
+}
+
+func (sc *scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case <-sc.runs:
+			sc.run(ctx)
+			sc.mu.Lock()
+			sc.running = len(sc.runs) > 0
+			sc.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (sc *scheduler) isRunning() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.running
+}
+
+func (sc *scheduler) nextRun() time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.next
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := parseSchedule(expr)
+		if err == nil {
+			t.Errorf("No error for invalid cron expression %q", expr)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// 2019-01-01 is tuesday
+	now := time.Date(2019, 1, 1, 10, 30, 15, 0, time.UTC)
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2019, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2019, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * *", time.Date(2019, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 8 * * *", time.Date(2019, 1, 2, 8, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2019, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2019, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 5", time.Date(2019, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"30 6 1,15 */3 *", time.Date(2019, 1, 15, 6, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseSchedule(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		next := s.next(now)
+		if !next.Equal(c.expected) {
+			t.Errorf("%s: wrong next run\nExpected: %s\nActual: %s\n", c.expr, c.expected, next)
+		}
+	}
+	s, _ := parseSchedule("0 0 30 2 *")
+	if !s.next(now).IsZero() {
+		t.Error("Impossible schedule matches")
+	}
+}
+
+func TestSchedule_NextLocation(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// clocks go forward at 2019-03-10 02:00 and back at 2019-11-03 02:00 in New York
+	cases := []struct {
+		expr     string
+		now      time.Time
+		expected time.Time
+	}{
+		{"0 11 * * *", time.Date(2019, 1, 1, 10, 30, 15, 0, kolkata), time.Date(2019, 1, 1, 11, 0, 0, 0, kolkata)},
+		{"*/15 * * * *", time.Date(2019, 1, 1, 10, 50, 0, 0, kolkata), time.Date(2019, 1, 1, 11, 0, 0, 0, kolkata)},
+		{"0 0 * * *", time.Date(2019, 1, 1, 10, 30, 0, 0, kolkata), time.Date(2019, 1, 2, 0, 0, 0, 0, kolkata)},
+		{"0 3 * * *", time.Date(2019, 3, 10, 1, 30, 0, 0, newYork), time.Date(2019, 3, 10, 3, 0, 0, 0, newYork)},
+		{"30 2 * * *", time.Date(2019, 3, 10, 1, 30, 0, 0, newYork), time.Date(2019, 3, 11, 2, 30, 0, 0, newYork)},
+		{"0 * * * *", time.Date(2019, 3, 10, 1, 30, 0, 0, newYork), time.Date(2019, 3, 10, 3, 0, 0, 0, newYork)},
+		{"0 2 * * *", time.Date(2019, 11, 3, 0, 30, 0, 0, newYork), time.Date(2019, 11, 3, 2, 0, 0, 0, newYork)},
+		// 01:30 EST is repeated wall clock time after 01:30 EDT
+		{"* * * * *", time.Date(2019, 11, 3, 6, 30, 0, 0, time.UTC).In(newYork), time.Date(2019, 11, 3, 6, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2019, 11, 3, 6, 30, 0, 0, time.UTC).In(newYork), time.Date(2019, 11, 3, 3, 0, 0, 0, newYork)},
+	}
+	for _, c := range cases {
+		s, err := parseSchedule(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := s.next(c.now)
+		if !next.Equal(c.expected) {
+			t.Errorf("%s after %s: wrong next run\nExpected: %s\nActual: %s\n", c.expr, c.now, c.expected, next)
+		}
+	}
+}
+
+func TestScheduler_Overlap(t *testing.T) {
+	for _, c := range []struct {
+		overlap  string
+		expected int
+	}{{overlapSkip, 1}, {overlapQueue, 2}} {
+		var mu sync.Mutex
+		runs := 0
+		release := make(chan struct{})
+		sc, err := newScheduler("* * * * *", c.overlap, func(ctx context.Context) {
+			mu.Lock()
+			runs++
+			mu.Unlock()
+			<-release
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go sc.work(ctx)
+		now := time.Now()
+		sc.trigger(now)
+		time.Sleep(10 * time.Millisecond)
+		// both due while first run is running, only one could be queued
+		sc.trigger(now.Add(time.Minute))
+		sc.trigger(now.Add(2 * time.Minute))
+		close(release)
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		mu.Lock()
+		if runs != c.expected {
+			t.Errorf("%s: wrong number of runs: %d", c.overlap, runs)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestNewScheduler_InvalidOverlap(t *testing.T) {
+	_, err := newScheduler("* * * * *", "parallel", func(ctx context.Context) {})
+	if err == nil {
+		t.Error("No error for unknown overlap policy")
+	}
+}
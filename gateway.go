@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// kernel gateway states
+const (
+	gatewayStopped    = "stopped"
+	gatewayStarting   = "starting"
+	gatewayReady      = "ready"
+	gatewayRestarting = "restarting"
+	gatewayFailed     = "failed"
+)
+
+const (
+	gatewayReadyTimeout   = 30 * time.Second
+	gatewayMinBackoff     = 100 * time.Millisecond
+	gatewayMaxBackoff     = 2 * time.Second
+	gatewayRestartBackoff = 30 * time.Second
+)
+
+// gatewayProbeInterval is interval of external gateway availability probes
+var gatewayProbeInterval = 5 * time.Second
+
+var gateway = &gatewaySupervisor{state: gatewayStopped, host: "localhost", port: 8888}
+
+// gatewaySupervisor runs jupyter kernel gateway process,
+// restarts it when it exits and notifies runners about restarts.
+// External gateway is managed by someone else and is only probed.
+type gatewaySupervisor struct {
+	stdout, stderr io.Writer
+	host           string
+	port           int
+	args           []string
+	external       bool
+
+	mu        sync.Mutex
+	state     string
+	cmd       *exec.Cmd
+	stopping  bool
+	onRestart []func()
+	// stopProbe stops external gateway probe, probeDone is closed when it returned
+	stopProbe chan struct{}
+	probeDone chan struct{}
+}
+
+// RunKernelGateway runs jupyter kernel gateway, waits until it's ready
+// and selects kernel by name
+// https://github.com/jupyter/kernel_gateway
+func RunKernelGateway(stdout, stderr io.Writer, kernelName string) error {
+	SetKernelName(kernelName)
+	gateway.stdout = stdout
+	gateway.stderr = stderr
+	// kernels are gone with restarted gateway, runner pools start new ones
+	gateway.OnRestart(func() { setKernelID("") })
+	err := gateway.Start()
+	if err != nil {
+		return err
+	}
+	return SelectKernel(kernelName)
+}
+
+// ConfigureGateway sets kernel gateway address and launch options.
+// Empty host and zero port keep default address.
+func ConfigureGateway(host string, port int, extraArgs string, external bool) {
+	if host != "" {
+		gateway.host = host
+	}
+	if port != 0 {
+		gateway.port = port
+	}
+	gateway.args = strings.Fields(extraArgs)
+	gateway.external = external
+	setGatewayAddress(gateway.addr())
+}
+
+// addr returns gateway host:port
+func (gs *gatewaySupervisor) addr() string {
+	return net.JoinHostPort(gs.host, strconv.Itoa(gs.port))
+}
+
+// Start starts kernel gateway process unless gateway is already running.
+// External gateway is never started, runner waits until it's ready
+// and probes it periodically.
+func (gs *gatewaySupervisor) Start() error {
+	if gs.external {
+		err := waitForGateway(gatewayReadyTimeout)
+		if err != nil {
+			gs.setState(gatewayFailed)
+			return fmt.Errorf("External kernel gateway at %s: %s", gs.addr(), err)
+		}
+		gs.setState(gatewayReady)
+		gs.mu.Lock()
+		gs.stopProbe = make(chan struct{})
+		gs.probeDone = make(chan struct{})
+		go gs.monitor(gs.stopProbe, gs.probeDone)
+		gs.mu.Unlock()
+		return nil
+	}
+	if isJupyterRunning() {
+		gs.setState(gatewayReady)
+		return nil
+	}
+	gs.setState(gatewayStarting)
+	err := gs.spawn()
+	if err != nil {
+		gs.setState(gatewayFailed)
+		return err
+	}
+	err = waitForGateway(gatewayReadyTimeout)
+	if err != nil {
+		gs.setState(gatewayFailed)
+		return err
+	}
+	gs.setState(gatewayReady)
+	return nil
+}
+
+// spawn starts gateway process and watches it
+func (gs *gatewaySupervisor) spawn() error {
+	path, err := exec.LookPath("jupyter")
+	if err != nil {
+		return fmt.Errorf("Jupyter kernel gateway is not installed: %s", err)
+	}
+	cmd := exec.Command(path, gs.command()...)
+	cmd.Stdout = gs.stdout
+	cmd.Stderr = gs.stderr
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("Error starting kernel gateway: %s", err)
+	}
+	gs.mu.Lock()
+	gs.cmd = cmd
+	gs.mu.Unlock()
+	go gs.watch(cmd)
+	return nil
+}
+
+// command returns kernel gateway command line arguments
+func (gs *gatewaySupervisor) command() []string {
+	command := []string{
+		"--NotebookApp.token=''",
+		"kernelgateway",
+		"--JupyterWebsocketPersonality.list_kernels=True",
+		fmt.Sprintf("--KernelGatewayApp.ip=%s", gs.host),
+		fmt.Sprintf("--KernelGatewayApp.port=%d", gs.port),
+	}
+	return append(command, gs.args...)
+}
+
+// watch waits for gateway process exit and restarts it
+func (gs *gatewaySupervisor) watch(cmd *exec.Cmd) {
+	err := cmd.Wait()
+	gs.mu.Lock()
+	stopping := gs.stopping
+	gs.mu.Unlock()
+	if stopping {
+		gs.setState(gatewayStopped)
+		return
+	}
+	log.Printf("Kernel gateway exited: %v", err)
+	gs.setState(gatewayRestarting)
+	backoff := time.Second
+	for {
+		err = gs.spawn()
+		if err == nil {
+			err = waitForGateway(gatewayReadyTimeout)
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("Error restarting kernel gateway: %s", err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > gatewayRestartBackoff {
+			backoff = gatewayRestartBackoff
+		}
+	}
+	log.Println("Kernel gateway restarted")
+	gs.restarted()
+}
+
+// restarted runs restart hooks and marks gateway ready when they are done,
+// so requests aren't accepted until runners recovered their kernels
+func (gs *gatewaySupervisor) restarted() {
+	gs.mu.Lock()
+	hooks := gs.onRestart
+	gs.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	gs.setState(gatewayReady)
+}
+
+// monitor probes external gateway until stop is closed
+func (gs *gatewaySupervisor) monitor(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(gatewayProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			gs.probe()
+		}
+	}
+}
+
+// probe updates external gateway state. Gateway becoming available again
+// is treated as restart, its kernels may be gone.
+func (gs *gatewaySupervisor) probe() {
+	running := isJupyterRunning()
+	state := gs.State()
+	switch {
+	case !running && state == gatewayReady:
+		log.Printf("External kernel gateway at %s is not available", gs.addr())
+		gs.setState(gatewayFailed)
+	case running && state == gatewayFailed:
+		log.Printf("External kernel gateway at %s is available again", gs.addr())
+		gs.setState(gatewayRestarting)
+		gs.restarted()
+	}
+}
+
+// OnRestart registers function called after gateway was restarted.
+// Gateway stays in restarting state until all hooks return.
+func (gs *gatewaySupervisor) OnRestart(hook func()) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.onRestart = append(gs.onRestart, hook)
+}
+
+// Stop terminates gateway process without restarting it
+// and stops external gateway probe
+func (gs *gatewaySupervisor) Stop() error {
+	gs.mu.Lock()
+	gs.stopping = true
+	stop, done := gs.stopProbe, gs.probeDone
+	gs.stopProbe = nil
+	cmd := gs.cmd
+	gs.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// State returns current gateway state
+func (gs *gatewaySupervisor) State() string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.state
+}
+
+func (gs *gatewaySupervisor) setState(state string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.state = state
+}
+
+// waitForGateway polls gateway api with backoff until it responds
+func waitForGateway(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := gatewayMinBackoff
+	for !isJupyterRunning() {
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("Kernel gateway is not ready after %s", timeout)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > gatewayMaxBackoff {
+			backoff = gatewayMaxBackoff
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForGateway(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version": "2.0.0"}`))
+	}))
+	defer ts.Close()
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = ts.URL
+	err := waitForGateway(5 * time.Second)
+	if err != nil {
+		t.Error(err)
+	}
+	if requests != 3 {
+		t.Errorf("Wrong number of readiness probes: %d", requests)
+	}
+}
+
+func TestWaitForGateway_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = ts.URL
+	err := waitForGateway(200 * time.Millisecond)
+	if err == nil {
+		t.Error("No error when gateway is not ready")
+	}
+}
+
+func TestGatewaySupervisor_MissingBinary(t *testing.T) {
+	defer func(path string) { os.Setenv("PATH", path) }(os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = "http://127.0.0.1:1"
+	gs := &gatewaySupervisor{state: gatewayStopped}
+	err := gs.Start()
+	if err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("Wrong error for missing gateway binary: %v", err)
+	}
+	if gs.State() != gatewayFailed {
+		t.Errorf("Wrong gateway state: %s", gs.State())
+	}
+}
+
+func TestGatewaySupervisor_External(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "2.0.0"}`))
+	}))
+	defer ts.Close()
+	defer func(path string) { os.Setenv("PATH", path) }(os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = ts.URL
+	gs := &gatewaySupervisor{state: gatewayStopped, external: true}
+	err := gs.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gs.Stop()
+	if gs.State() != gatewayReady || gs.cmd != nil {
+		t.Errorf("External gateway is not used: %s", gs.State())
+	}
+}
+
+// waitForState waits until gateway is in state
+func waitForState(t *testing.T, gs *gatewaySupervisor, state string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for gs.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("Gateway is %s instead of %s", gs.State(), state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGatewaySupervisor_ExternalProbe(t *testing.T) {
+	var available int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&available) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version": "2.0.0"}`))
+	}))
+	defer ts.Close()
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = ts.URL
+	defer func(interval time.Duration) { gatewayProbeInterval = interval }(gatewayProbeInterval)
+	gatewayProbeInterval = 10 * time.Millisecond
+	gs := &gatewaySupervisor{state: gatewayStopped, external: true}
+	restarts := make(chan struct{}, 1)
+	gs.OnRestart(func() { restarts <- struct{}{} })
+	err := gs.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gs.Stop()
+	atomic.StoreInt32(&available, 0)
+	waitForState(t, gs, gatewayFailed)
+	atomic.StoreInt32(&available, 1)
+	waitForState(t, gs, gatewayReady)
+	select {
+	case <-restarts:
+	default:
+		t.Error("Restart hooks are not run when external gateway is available again")
+	}
+}
+
+func TestGatewaySupervisor_Restarted(t *testing.T) {
+	gs := &gatewaySupervisor{state: gatewayRestarting}
+	hooks := 0
+	for i := 0; i < 2; i++ {
+		gs.OnRestart(func() {
+			hooks++
+			if gs.State() != gatewayRestarting {
+				t.Errorf("Gateway is %s before restart hooks are done", gs.State())
+			}
+		})
+	}
+	gs.restarted()
+	if hooks != 2 || gs.State() != gatewayReady {
+		t.Errorf("Wrong restart: %d hooks run, gateway is %s", hooks, gs.State())
+	}
+}
+
+func TestGatewaySupervisor_Command(t *testing.T) {
+	gs := &gatewaySupervisor{host: "0.0.0.0", port: 9999, args: []string{"--KernelGatewayApp.allow_origin=*"}}
+	command := strings.Join(gs.command(), " ")
+	for _, arg := range []string{"kernelgateway", "--KernelGatewayApp.ip=0.0.0.0", "--KernelGatewayApp.port=9999", "--KernelGatewayApp.allow_origin=*"} {
+		if !strings.Contains(command, arg) {
+			t.Errorf("Argument %s is missing in %s", arg, command)
+		}
+	}
+	if gs.addr() != "0.0.0.0:9999" {
+		t.Errorf("Wrong gateway address: %s", gs.addr())
+	}
+}
+
+// TestGatewayRestart_KernelID checks restart hook updating current
+// kernel while requests read it, run with -race
+func TestGatewayRestart_KernelID(t *testing.T) {
+	defer func(k kernel) { currentKernel = k }(currentKernel)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			setKernelID(fmt.Sprintf("kernel-%d", i))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if code, _ := functionCall("test", nil); code == "" {
+			t.Fatal("Function call is not created")
+		}
+		getCurrentKernel()
+	}
+	<-done
+	if id := getCurrentKernel().ID; id != "kernel-99" {
+		t.Errorf("Wrong kernel id: %s", id)
+	}
+}
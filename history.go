@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// historyName is run history file in resource dir
+const historyName = ".run_history.jsonl"
+
+// historyLimit is number of newest records kept when history is compacted
+const historyLimit = 1000
+
+// run statuses
+const (
+	runSucceeded = "ok"
+	runFailed    = "error"
+	runTimedOut  = "timeout"
+)
+
+// runRecord is single function run of cron server
+type runRecord struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Duration is number of milliseconds
+	Duration  time.Duration `json:"duration"`
+	Status    string        `json:"status"`
+	Attempt   int           `json:"attempt"`
+	Result    string        `json:"result,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Traceback []string      `json:"traceback,omitempty"`
+	*Output
+
+	err    error
+	bundle map[string]interface{}
+}
+
+// newRunRecord creates record of run started at start
+func newRunRecord(start time.Time, res *result, err error) *runRecord {
+	end := time.Now().UTC()
+	rec := &runRecord{
+		ID:       uuid.Must(uuid.NewV4()).String(),
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start) / time.Millisecond,
+		Status:   runSucceeded,
+		Output:   res.output(),
+		err:      err,
+		bundle:   res.bundle,
+	}
+	var buf outputBuffer
+	buf.write(res.text())
+	rec.Result = buf.String()
+	switch err {
+	case nil:
+	case context.DeadlineExceeded:
+		rec.Status = runTimedOut
+		rec.Error = "Script execution timed out"
+	default:
+		rec.Status = runFailed
+		rec.Error = err.Error()
+		if ke, ok := err.(*kernelError); ok {
+			rec.Traceback = ke.Traceback
+		}
+	}
+	return rec
+}
+
+// runHistory is append only json lines file of run records.
+// File is compacted to limit newest records when it grows to twice the limit,
+// so reading it stays cheap.
+type runHistory struct {
+	path  string
+	limit int
+	mu    sync.Mutex
+	// count is number of records in file, -1 until file is read
+	count int
+}
+
+func newRunHistory(dir string) *runHistory {
+	return &runHistory{path: filepath.Join(dir, historyName), limit: historyLimit, count: -1}
+}
+
+// Append writes record to the end of history with single write.
+// Record starts on new line even if last line was written partially.
+func (h *runHistory) Append(rec *runRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	torn, err := endsPartially(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if torn {
+		line = append([]byte{'\n'}, line...)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	if h.count < 0 {
+		h.count = 0
+		err = h.read(func(*runRecord) { h.count++ })
+		if err != nil {
+			return err
+		}
+	} else {
+		h.count++
+	}
+	if h.count > 2*h.limit {
+		return h.compact()
+	}
+	return nil
+}
+
+// compact rewrites history with limit newest records. Last successful
+// and last failed runs are kept even if they are older, so status
+// doesn't lose them.
+func (h *runHistory) compact() error {
+	records := []*runRecord{}
+	err := h.read(func(rec *runRecord) { records = append(records, rec) })
+	if err != nil {
+		return err
+	}
+	start := len(records) - h.limit
+	if start < 0 {
+		start = 0
+	}
+	lastSuccess, lastFailure := -1, -1
+	for i, rec := range records {
+		if rec.Status == runSucceeded {
+			lastSuccess = i
+		} else {
+			lastFailure = i
+		}
+	}
+	var buf bytes.Buffer
+	count := 0
+	for i, rec := range records {
+		if i < start && i != lastSuccess && i != lastFailure {
+			continue
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+		count++
+	}
+	tmp := h.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, h.path)
+	if err != nil {
+		return err
+	}
+	h.count = count
+	return nil
+}
+
+// endsPartially checks if file doesn't end with new line
+func endsPartially(f *os.File) (bool, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, info.Size()-1)
+	return last[0] != '\n', err
+}
+
+// each calls fn for every record from oldest to newest.
+// Lines which can't be decoded, e.g. partially written, are skipped.
+func (h *runHistory) each(fn func(rec *runRecord)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.read(fn)
+}
+
+// read is each without locking history
+func (h *runHistory) read(fn func(rec *runRecord)) error {
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			rec := &runRecord{}
+			if json.Unmarshal(line, rec) == nil {
+				fn(rec)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Recent returns at most limit newest records, newest first
+func (h *runHistory) Recent(limit int) ([]*runRecord, error) {
+	records := []*runRecord{}
+	err := h.each(func(rec *runRecord) {
+		records = append(records, rec)
+		if len(records) > limit {
+			records = records[1:]
+		}
+	})
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, err
+}
+
+// runStatus is summary of cron server runs
+type runStatus struct {
+	Schedule    string     `json:"schedule"`
+	Running     bool       `json:"running"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	LastRun     *runRecord `json:"last_run"`
+	LastSuccess *runRecord `json:"last_success"`
+	LastFailure *runRecord `json:"last_failure"`
+}
+
+// Status returns last run, last successful and last failed runs
+func (h *runHistory) Status() (*runStatus, error) {
+	status := &runStatus{}
+	err := h.each(func(rec *runRecord) {
+		status.LastRun = rec
+		if rec.Status == runSucceeded {
+			status.LastSuccess = rec
+		} else {
+			status.LastFailure = rec
+		}
+	})
+	return status, err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewRunRecord(t *testing.T) {
+	defer func(max int) { args.MaxOutput = max }(args.MaxOutput)
+	args.MaxOutput = 4
+	res := &result{bundle: map[string]interface{}{"text/plain": "'result'"}}
+	res.stdout.write("out")
+	rec := newRunRecord(time.Now().UTC(), res, nil)
+	if rec.Status != runSucceeded || rec.Result != "'res" || rec.Stdout != "out" {
+		t.Errorf("Wrong run record: %+v", rec)
+	}
+	ke := &kernelError{Name: "ValueError", Value: "bad", Traceback: []string{"Traceback", "ValueError: bad"}}
+	rec = newRunRecord(time.Now().UTC(), &result{}, ke)
+	if rec.Status != runFailed || rec.Error != "ValueError: bad" || len(rec.Traceback) != 2 {
+		t.Errorf("Wrong failed run record: %+v", rec)
+	}
+	rec = newRunRecord(time.Now().UTC(), &result{}, context.DeadlineExceeded)
+	if rec.Status != runTimedOut {
+		t.Errorf("Wrong timed out run record: %+v", rec)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h := newRunHistory(dir)
+	status, err := h.Status()
+	if err != nil || status.LastRun != nil {
+		t.Errorf("Wrong status of empty history: %+v, %v", status, err)
+	}
+	for _, s := range []string{runSucceeded, runFailed, runSucceeded, runTimedOut} {
+		err = h.Append(&runRecord{ID: s, Status: s})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// partially written record
+	f, _ := os.OpenFile(filepath.Join(dir, historyName), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"id": "broken`)
+	f.Close()
+	runs, err := h.Recent(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 || runs[0].Status != runTimedOut || runs[2].Status != runFailed {
+		t.Errorf("Wrong recent runs: %+v", runs)
+	}
+	status, err = h.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastRun.Status != runTimedOut || status.LastSuccess.Status != runSucceeded || status.LastFailure.Status != runTimedOut {
+		t.Errorf("Wrong status: %+v", status)
+	}
+	err = h.Append(&runRecord{ID: "after-broken", Status: runSucceeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs, err = h.Recent(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != "after-broken" || runs[1].Status != runTimedOut {
+		t.Errorf("Record appended after partial line is lost: %+v", runs)
+	}
+}
+
+func TestRunHistory_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h := newRunHistory(dir)
+	h.limit = 3
+	err = h.Append(&runRecord{ID: "success", Status: runSucceeded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		err = h.Append(&runRecord{ID: fmt.Sprintf("failure-%d", i), Status: runFailed})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := h.Recent(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) > 2*h.limit || runs[0].ID != "failure-19" {
+		t.Errorf("History is not compacted: %d records, newest %s", len(runs), runs[0].ID)
+	}
+	status, err := h.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastSuccess == nil || status.LastSuccess.ID != "success" || status.LastRun.ID != "failure-19" {
+		t.Errorf("Wrong status of compacted history: %+v", status)
+	}
+	// history reopened by restarted server counts existing records
+	h = newRunHistory(dir)
+	h.limit = 3
+	for i := 0; i < 3; i++ {
+		err = h.Append(&runRecord{ID: "reopened", Status: runFailed})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err = h.Recent(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) > 2*h.limit {
+		t.Errorf("Reopened history is not compacted: %d records", len(runs))
+	}
+}
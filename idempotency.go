@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	cache "github.com/patrickmn/go-cache"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader marks response replayed for idempotency key
+	replayedHeader = "Idempotent-Replayed"
+	// defaultIdempotencyTTL is used unless positive args.IdempotencyTTL is set
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+// idempotencyStore keeps responses by idempotency key for args.IdempotencyTTL
+var idempotencyStore = cache.New(cache.NoExpiration, 10*time.Minute)
+
+// idempotentResponse is response recorded for idempotency key.
+// Done is closed when handler returned, recorded is false if it panicked.
+type idempotentResponse struct {
+	done        chan struct{}
+	fingerprint [sha256.Size]byte
+	recorded    bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// responseRecorder writes response and keeps its copy
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// idempotent runs handler once for every Idempotency-Key and replays
+// its response to retried requests. Duplicates arriving while request
+// is running wait for its response. Keys are scoped by path and token.
+func idempotent(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || r.Method != http.MethodPost {
+			handler(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			appErr := AppError{Err: err, StatusCode: http.StatusBadRequest}
+			appErr.Write(context.Background(), w)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		storeKey := strings.Join([]string{r.URL.Path, r.URL.Query().Get("access_token"), key}, "\x00")
+		resp := &idempotentResponse{done: make(chan struct{}), fingerprint: sha256.Sum256(body)}
+		for {
+			err = idempotencyStore.Add(storeKey, resp, cache.NoExpiration)
+			if err == nil {
+				break
+			}
+			if value, found := idempotencyStore.Get(storeKey); found && replay(w, r, value.(*idempotentResponse), resp.fingerprint) {
+				return
+			}
+		}
+		defer func() {
+			// duplicates run request once again if handler panicked
+			if !resp.recorded {
+				idempotencyStore.Delete(storeKey)
+			}
+			close(resp.done)
+		}()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(rec, r)
+		resp.status = rec.status
+		resp.header = http.Header{}
+		for name, values := range w.Header() {
+			resp.header[name] = values
+		}
+		resp.body = rec.body.Bytes()
+		resp.recorded = true
+		if !isFinalStatus(resp.status) {
+			idempotencyStore.Delete(storeKey)
+			return
+		}
+		ttl := args.IdempotencyTTL
+		if ttl <= 0 {
+			ttl = defaultIdempotencyTTL
+		}
+		idempotencyStore.Set(storeKey, resp, ttl)
+	}
+}
+
+// isFinalStatus checks if response status is final outcome of request, which
+// doesn't change when request is retried. Transient failures like timeouts,
+// cancellations and kernel errors are not final, retries run request again.
+func isFinalStatus(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnprocessableEntity:
+		return true
+	}
+	return status >= http.StatusOK && status < http.StatusMultipleChoices
+}
+
+// replay waits for recorded response and writes it. It returns false
+// if there is no response to replay, because handler panicked.
+func replay(w http.ResponseWriter, r *http.Request, resp *idempotentResponse, fingerprint [sha256.Size]byte) bool {
+	ctx := context.Background()
+	if resp.fingerprint != fingerprint {
+		appErr := AppError{
+			StatusCode: http.StatusUnprocessableEntity,
+			Reason:     "Idempotency-Key was already used with different request",
+		}
+		appErr.Write(ctx, w)
+		return true
+	}
+	select {
+	case <-resp.done:
+	case <-r.Context().Done():
+		return true
+	}
+	if !resp.recorded {
+		return false
+	}
+	for name, values := range resp.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+	return true
+}
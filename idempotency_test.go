@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	idempotencyStore.Flush()
+	var mu sync.Mutex
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call": %d}`, n)
+	})
+	request := func(key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/?access_token=token", strings.NewReader(body))
+		r.Header.Set(idempotencyHeader, key)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 3)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = request("key-1", `{"data": 1}`)
+		}(i)
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Concurrent duplicates are executed: %d calls", calls)
+	}
+	replayed := 0
+	for _, w := range responses {
+		if w.Code != http.StatusCreated || w.Body.String() != `{"call": 1}` || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Wrong replayed response: %d %s", w.Code, w.Body)
+		}
+		if w.Header().Get(replayedHeader) == "true" {
+			replayed++
+		}
+	}
+	if replayed != 2 {
+		t.Errorf("Wrong number of replayed responses: %d", replayed)
+	}
+	w := request("key-1", `{"data": 2}`)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Key reused with different request: %d", w.Code)
+	}
+	w = request("key-2", `{"data": 1}`)
+	if w.Body.String() != `{"call": 2}` {
+		t.Errorf("Different key is replayed: %s", w.Body)
+	}
+}
+
+func TestIdempotent_ServerError(t *testing.T) {
+	idempotencyStore.Flush()
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		r.Header.Set(idempotencyHeader, "key-3")
+		handler(httptest.NewRecorder(), r)
+	}
+	if calls != 2 {
+		t.Errorf("Server error is replayed: %d calls", calls)
+	}
+}
+
+func TestIdempotent_Panic(t *testing.T) {
+	idempotencyStore.Flush()
+	var mu sync.Mutex
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			time.Sleep(20 * time.Millisecond)
+			panic("handler failed")
+		}
+		fmt.Fprint(w, "ok")
+	})
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		r.Header.Set(idempotencyHeader, "key-4")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	go func() {
+		defer func() { recover() }()
+		request()
+	}()
+	time.Sleep(5 * time.Millisecond)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- request() }()
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Errorf("Wrong response after panic: %d %s", w.Code, w.Body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Duplicate request is blocked after panic")
+	}
+	if calls != 2 {
+		t.Errorf("Request is not executed again after panic: %d calls", calls)
+	}
+}
+
+func TestIsFinalStatus(t *testing.T) {
+	for status, final := range map[int]bool{
+		http.StatusOK:                  true,
+		http.StatusAccepted:            true,
+		http.StatusBadRequest:          true,
+		http.StatusUnprocessableEntity: true,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+		http.StatusBadGateway:          false,
+		http.StatusServiceUnavailable:  false,
+		http.StatusGatewayTimeout:      false,
+	} {
+		if isFinalStatus(status) != final {
+			t.Errorf("Wrong final status %d: %t", status, !final)
+		}
+	}
+}
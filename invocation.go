@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+)
+
+// callTemplates are function call templates by kernel language.
+// Data is base64 literal decoded by kernel, so any payload reaches
+// the function unchanged and can't escape the call expression.
+var callTemplates = map[string]*template.Template{
+	"python": template.Must(template.New("python").Parse(
+		`{{.Function}}({{if .Data}}__import__('base64').b64decode('{{.Data}}').decode('utf-8'){{end}})`)),
+	// IRkernel depends on base64enc
+	"r": template.Must(template.New("r").Parse(
+		`{{.Function}}({{if .Data}}rawToChar(base64enc::base64decode('{{.Data}}')){{end}})`)),
+	"julia": template.Must(template.New("julia").Parse(
+		`{{if .Data}}import Base64; {{end}}{{.Function}}({{if .Data}}String(Base64.base64decode("{{.Data}}")){{end}})`)),
+}
+
+// customCallTemplate is user call template overriding language templates
+var customCallTemplate *template.Template
+
+// call is function call template data
+type call struct {
+	Function string
+	Data     string
+}
+
+// SetCallTemplate sets user function call template used for any kernel language.
+// Empty text restores language templates.
+func SetCallTemplate(text string) error {
+	if text == "" {
+		customCallTemplate = nil
+		return nil
+	}
+	t, err := template.New("custom").Parse(text)
+	if err == nil {
+		err = t.Execute(ioutil.Discard, &call{Function: "test", Data: "e30="})
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid call template: %s", err)
+	}
+	customCallTemplate = t
+	return nil
+}
+
+// callTemplate returns function call template for kernel language
+func callTemplate(language string) (*template.Template, error) {
+	if customCallTemplate != nil {
+		return customCallTemplate, nil
+	}
+	if language == "" {
+		language = "python"
+	}
+	t, ok := callTemplates[language]
+	if !ok {
+		return nil, fmt.Errorf("Function call syntax of %s kernel is unknown, set call template", language)
+	}
+	return t, nil
+}
+
+// functionCall creates code calling function with data as its argument
+// in current kernel language. Function is called without arguments if data is empty.
+func functionCall(function string, data []byte) (string, error) {
+	language := getCurrentKernel().Language
+	t, err := callTemplate(language)
+	if err != nil {
+		return "", err
+	}
+	var code bytes.Buffer
+	err = t.Execute(&code, &call{Function: function, Data: base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return "", fmt.Errorf("Error creating %s function call from %s template: %s", function, t.Name(), err)
+	}
+	return code.String(), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFunctionCall_Languages(t *testing.T) {
+	defer func(language string) { currentKernel.Language = language }(currentKernel.Language)
+	cases := []struct {
+		language string
+		data     []byte
+		expected string
+	}{
+		{"python", nil, `test()`},
+		{"python", []byte(`{}`), `test(__import__('base64').b64decode('e30=').decode('utf-8'))`},
+		{"r", nil, `test()`},
+		{"r", []byte(`{}`), `test(rawToChar(base64enc::base64decode('e30=')))`},
+		{"julia", nil, `test()`},
+		{"julia", []byte(`{}`), `import Base64; test(String(Base64.base64decode("e30=")))`},
+	}
+	for _, c := range cases {
+		currentKernel.Language = c.language
+		code, err := functionCall("test", c.data)
+		if err != nil {
+			t.Error(err)
+		}
+		if code != c.expected {
+			t.Errorf("Wrong %s function call\nExpected: %s\nActual: %s\n", c.language, c.expected, code)
+		}
+	}
+}
+
+func TestSetCallTemplate(t *testing.T) {
+	defer SetCallTemplate("")
+	err := SetCallTemplate(`{{.Unknown}}`)
+	if err == nil {
+		t.Error("No error for invalid call template")
+	}
+	err = SetCallTemplate(`(call {{.Function}} "{{.Data}}")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = callTemplate("scheme")
+	if err != nil {
+		t.Error(err)
+	}
+	code, err := functionCall("test", []byte(`{}`))
+	if err != nil {
+		t.Error(err)
+	}
+	if code != `(call test "e30=")` {
+		t.Errorf("Wrong custom function call: %s", code)
+	}
+	SetCallTemplate("")
+	_, err = callTemplate("scheme")
+	if err == nil {
+		t.Error("No error for unknown kernel language")
+	}
+}
+
+func TestFunctionCall_Errors(t *testing.T) {
+	defer func(language string) { currentKernel.Language = language }(currentKernel.Language)
+	defer SetCallTemplate("")
+	currentKernel.Language = "scheme"
+	_, err := functionCall("test", nil)
+	if err == nil || !strings.Contains(err.Error(), "scheme") {
+		t.Errorf("Wrong unknown language error: %v", err)
+	}
+	err = SetCallTemplate(`{{.Function}}({{index .Function 3}})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := functionCall("f", []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "custom template") || code != "" {
+		t.Errorf("Wrong template execution error: %v, code %q", err, code)
+	}
+}
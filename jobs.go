@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cache "github.com/patrickmn/go-cache"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// jobsPath is path prefix of async job resources
+	jobsPath = "/jobs/"
+	// asyncParam requests async execution of script
+	asyncParam = "async"
+)
+
+// job statuses before job is finished with ok or error status
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+)
+
+// jobs are async jobs by id. Unfinished jobs never expire,
+// finished ones are kept for args.JobRetention.
+var jobs = cache.New(cache.NoExpiration, time.Minute)
+
+// job is script running in background
+type job struct {
+	id     string
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	resp     *Response
+	finished bool
+}
+
+// isAsync checks if async execution is requested
+func isAsync(r *http.Request) bool {
+	async, _ := strconv.ParseBool(r.URL.Query().Get(asyncParam))
+	return async
+}
+
+// asyncTimeout returns timeout of async job. Job is limited
+// by args.MaxRequestTimeout unless request sets shorter timeout.
+func asyncTimeout(r *http.Request) time.Duration {
+	if r.Header.Get(timeoutHeader) == "" {
+		return args.MaxRequestTimeout
+	}
+	timeout, _ := requestTimeout(r)
+	return timeout
+}
+
+// startJob starts running code on model kernels in background
+func startJob(r *http.Request, m *model, code string, requestData *Request, capture bool) *job {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout := asyncTimeout(r); timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	j := &job{
+		id:     uuid.Must(uuid.NewV4()).String(),
+		cancel: cancel,
+		resp:   CreateResponseFromRequest(requestData),
+	}
+	j.resp.Status = jobPending
+	j.resp.JobID = j.id
+	jobs.Set(j.id, j, cache.NoExpiration)
+	go func() {
+		defer cancel()
+		// kernel reports busy status as soon as execution starts
+		ctx = withMessages(ctx, func(*msg) { j.start() })
+		j.finish(runResponse(ctx, m, code, requestData, capture))
+		if requestData.CallbackURL != "" {
+			resp, _ := j.response()
+			notifyCallback(requestData.CallbackURL, resp)
+		}
+	}()
+	return j
+}
+
+func (j *job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.resp.Status == jobPending {
+		j.resp.Status = jobRunning
+	}
+}
+
+// finish sets job response and schedules job expiration
+func (j *job) finish(resp *Response) {
+	resp.JobID = j.id
+	j.mu.Lock()
+	j.resp = resp
+	j.finished = true
+	j.mu.Unlock()
+	jobs.Set(j.id, j, args.JobRetention)
+}
+
+// response returns copy of current job response
+func (j *job) response() (*Response, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	resp := *j.resp
+	return &resp, j.finished
+}
+
+// writeJob writes job response with given status code
+func writeJob(ctx context.Context, w http.ResponseWriter, statusCode int, j *job) {
+	resp, _ := j.response()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", jobsPath+j.id)
+	w.WriteHeader(statusCode)
+	resp.Write(ctx, w)
+}
+
+// JobHandler returns async job response on GET and cancels job on DELETE.
+// Deleting finished job removes its result.
+func JobHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		appErr := AppError{StatusCode: http.StatusMethodNotAllowed}
+		appErr.Write(ctx, w)
+		return
+	}
+	if !checkToken(args.ApiRoot, r.URL.Query().Get("access_token")) {
+		appErr := AppError{StatusCode: http.StatusForbidden}
+		appErr.Write(ctx, w)
+		return
+	}
+	value, found := jobs.Get(strings.TrimPrefix(r.URL.Path, jobsPath))
+	if !found {
+		appErr := AppError{StatusCode: http.StatusNotFound, Reason: "Job not found"}
+		appErr.Write(ctx, w)
+		return
+	}
+	j := value.(*job)
+	if r.Method == http.MethodGet {
+		writeJob(ctx, w, http.StatusOK, j)
+		return
+	}
+	if _, finished := j.response(); finished {
+		jobs.Delete(j.id)
+		writeJob(ctx, w, http.StatusOK, j)
+		return
+	}
+	j.cancel()
+	writeJob(ctx, w, http.StatusAccepted, j)
+}
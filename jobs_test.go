@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// waitRunning waits until job is running
+func waitRunning(t *testing.T, j *job) {
+	for i := 0; i < 100; i++ {
+		if resp, _ := j.response(); resp.Status == jobRunning {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Job is not running")
+}
+
+// waitJob waits until job is finished
+func waitJob(t *testing.T, j *job) *Response {
+	for i := 0; i < 100; i++ {
+		if resp, finished := j.response(); finished {
+			return resp
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Job is not finished")
+	return nil
+}
+
+func TestStartJob(t *testing.T) {
+	release := make(chan struct{})
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		websocket.JSON.Send(ws, replyMsg(req, "status", map[string]interface{}{"execution_state": "busy"}))
+		<-release
+		websocket.JSON.Send(ws, executeResult(req, "'done'"))
+		finish(ws, req)
+	})
+	defer ts.Close()
+	defer s.Close()
+	p, restore := withTestPool(s)
+	defer restore()
+	m := &model{pool: p}
+	args.JobRetention = time.Minute
+	r := httptest.NewRequest("POST", "/?async=true", nil)
+	if !isAsync(r) {
+		t.Error("Async request is not detected")
+	}
+	j := startJob(r, m, "test()", &Request{}, false)
+	if value, found := jobs.Get(j.id); !found || value.(*job) != j {
+		t.Error("Job is not stored")
+	}
+	waitRunning(t, j)
+	if resp, _ := j.response(); resp.Status != jobRunning || resp.JobID != j.id {
+		t.Errorf("Wrong running job response: %+v", resp)
+	}
+	close(release)
+	resp := waitJob(t, j)
+	if resp.Status != "ok" || string(resp.Data) != `"done"` || resp.JobID != j.id {
+		t.Errorf("Wrong finished job response: %+v", resp)
+	}
+}
+
+func TestStartJob_Cancel(t *testing.T) {
+	interrupted := make(chan string, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		interrupted <- r.URL.Path
+	}))
+	defer gateway.Close()
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = gateway.URL
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		websocket.JSON.Send(ws, replyMsg(req, "status", map[string]interface{}{"execution_state": "busy"}))
+	})
+	defer ts.Close()
+	defer s.Close()
+	s.kernel = &kernel{Name: "python", ID: "test"}
+	p, restore := withTestPool(s)
+	defer restore()
+	m := &model{pool: p}
+	j := startJob(httptest.NewRequest("POST", "/?async=1", nil), m, "while True: pass", &Request{}, false)
+	waitRunning(t, j)
+	j.cancel()
+	resp := waitJob(t, j)
+	if resp.Status != "error" || resp.Reason != "Script execution was cancelled" {
+		t.Errorf("Wrong cancelled job response: %+v", resp)
+	}
+	select {
+	case <-interrupted:
+	case <-time.After(time.Second):
+		t.Error("Kernel is not interrupted")
+	}
+}
+
+func TestStartJob_Callback(t *testing.T) {
+	callbacks := make(chan *Response, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &Response{}
+		json.NewDecoder(r.Body).Decode(resp)
+		callbacks <- resp
+	}))
+	defer receiver.Close()
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		websocket.JSON.Send(ws, executeResult(req, "'done'"))
+		finish(ws, req)
+	})
+	defer ts.Close()
+	defer s.Close()
+	p, restore := withTestPool(s)
+	defer restore()
+	m := &model{pool: p}
+	j := startJob(httptest.NewRequest("POST", "/?async=1", nil), m, "test()", &Request{CallbackURL: receiver.URL}, false)
+	select {
+	case resp := <-callbacks:
+		if resp.JobID != j.id || resp.Status != "ok" || string(resp.Data) != `"done"` {
+			t.Errorf("Wrong callback response: %+v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Error("Callback is not notified")
+	}
+}
@@ -2,33 +2,35 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/satori/go.uuid"
 	"golang.org/x/net/websocket"
 )
 
-// domain is default jupyter kernel gateway listening address
-// TODO: port should not be hardcoded
-const domain = "localhost:8888"
+// defaultGatewayAddr is default jupyter kernel gateway listening address
+const defaultGatewayAddr = "localhost:8888"
 
 var (
-	baseURI       = fmt.Sprintf("http://%s", domain)
-	wsURI         = fmt.Sprintf("ws://%s", domain)
+	baseURI       = fmt.Sprintf("http://%s", defaultGatewayAddr)
+	wsURI         = fmt.Sprintf("ws://%s", defaultGatewayAddr)
 	currentKernel = kernel{Name: "python"}
-	kgPID         int
+	// kernelMu guards currentKernel, which is updated when gateway restarts
+	kernelMu      sync.RWMutex
+	gatewayClient = &http.Client{Timeout: 5 * time.Second}
+
+	ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
 )
 
 // msg is jupyter message implementation
@@ -51,76 +53,27 @@ type header struct {
 	Date     string `json:"date"`
 }
 
-// kernel represents jupyter kernel info
+// kernel represents jupyter kernel info,
+// language is taken from kernelspec
 type kernel struct {
-	Name string `json:"name"`
-	ID   string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	ID       string `json:"id,omitempty"`
+	Language string `json:"-"`
 }
 
-func isJupyterRunning() bool {
-	_, err := http.Get(fmt.Sprintf("%s/api", baseURI))
-	if err != nil {
-		return false
-	}
-	return true
-}
-
-// RunKernelGateway runs jupyter kernel gateway
-// https://github.com/jupyter/kernel_gateway
-func RunKernelGateway(stdout, stderr io.Writer, kernelName string) {
-	if isJupyterRunning() {
-		return
-	}
-	currentKernel.Name = kernelName
-	cmd := exec.Command(
-		"jupyter",
-		"--NotebookApp.token=''",
-		"kernelgateway",
-		"--JupyterWebsocketPersonality.list_kernels=True",
-	)
-	cmd.Stderr = stdout
-	cmd.Stdout = stderr
-	err := cmd.Start()
-	if err != nil {
-		log.Fatal(err)
-	}
-	kgPID = cmd.Process.Pid
-	go func() {
-		log.Printf("Error starting kernel gateway: %s", cmd.Wait())
-	}()
+// setGatewayAddress points kernel gateway clients to given host:port
+func setGatewayAddress(addr string) {
+	baseURI = fmt.Sprintf("http://%s", addr)
+	wsURI = fmt.Sprintf("ws://%s", addr)
 }
 
-// Run sends code to jupyter kernel for processing
-func Run(ctx context.Context, script, function string) (string, time.Duration, error) {
-	duration := time.Duration(0)
-	ws := dialKernelWebSocket()
-	respCh := make(chan string)
-	errCh := make(chan string)
-	go handleWebSocket(ws, respCh, errCh)
-	scriptContent, err := scriptContent(script)
-	if err != nil {
-		return "", duration, err
-	}
-	err = websocket.JSON.Send(ws, createExecuteMsg(scriptContent))
-	if err != nil {
-		return "", duration, err
-	}
-	err = websocket.JSON.Send(ws, createExecuteMsg(function))
+func isJupyterRunning() bool {
+	resp, err := gatewayClient.Get(fmt.Sprintf("%s/api", baseURI))
 	if err != nil {
-		return "", duration, err
-	}
-	var data string
-	start := time.Now().UTC()
-	select {
-	case data = <-respCh:
-		data = strings.Trim(data, "'")
-	case data = <-errCh:
-		err = errors.New("Script error")
-		break
-	case <-ctx.Done():
+		return false
 	}
-	duration = time.Now().UTC().Sub(start) / time.Millisecond
-	return data, duration, err
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
 }
 
 func scriptContent(script string) (string, error) {
@@ -134,18 +87,35 @@ func scriptContent(script string) (string, error) {
 
 // SetKernelName sets currentKernel name
 func SetKernelName(name string) {
+	kernelMu.Lock()
+	defer kernelMu.Unlock()
 	currentKernel.Name = name
 }
 
+// getCurrentKernel returns copy of currentKernel
+func getCurrentKernel() kernel {
+	kernelMu.RLock()
+	defer kernelMu.RUnlock()
+	return currentKernel
+}
+
+// setKernelID sets currentKernel id
+func setKernelID(id string) {
+	kernelMu.Lock()
+	defer kernelMu.Unlock()
+	currentKernel.ID = id
+}
+
 func getKernelURI() string {
 	return fmt.Sprintf(`%s/api/kernels`, baseURI)
 }
 
-func isKernelRunning(name string) bool {
+// runningKernel returns running kernel with given name or nil
+func runningKernel(name string) *kernel {
 	uri := getKernelURI()
 	resp, err := http.Get(uri)
 	if err != nil {
-		return false
+		return nil
 	}
 	if resp != nil {
 		defer resp.Body.Close()
@@ -153,41 +123,85 @@ func isKernelRunning(name string) bool {
 	runningKernels := []kernel{}
 	err = json.NewDecoder(resp.Body).Decode(&runningKernels)
 	if err != nil {
-		return false
+		return nil
 	}
 	for _, kernel := range runningKernels {
 		if kernel.Name == name {
-			return true
+			return &kernel
 		}
 	}
-	return false
+	return nil
 }
 
 func startKernel(k *kernel) {
-	var body bytes.Buffer
-	json.NewEncoder(&body).Encode(k)
-	uri := getKernelURI()
-	// TODO: this could be handled better
-	time.Sleep(2 * time.Second)
-	response, err := http.Post(uri, "application/json", &body)
+	started, err := createKernel(k.Name)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	if response != nil {
-		defer response.Body.Close()
+	k.ID = started.ID
+}
+
+// createKernel starts new kernel process with given name
+func createKernel(name string) (*kernel, error) {
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(&kernel{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.Post(getKernelURI(), "application/json", &body)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("Error starting kernel %s: %s", name, response.Status)
 	}
-	err = json.NewDecoder(response.Body).Decode(&currentKernel)
+	k := &kernel{}
+	err = json.NewDecoder(response.Body).Decode(k)
 	if err != nil {
-		log.Printf("Error decoding kernel: %s", err)
+		return nil, fmt.Errorf("Error decoding kernel: %s", err)
 	}
+	return k, nil
 }
 
-// GetKernel gets kernel id by name and starts kernel process
+// restartKernel restarts kernel process keeping kernel id
+func restartKernel(id string) error {
+	uri := fmt.Sprintf("%s/%s/restart", getKernelURI(), id)
+	response, err := http.Post(uri, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("Error restarting kernel %s: %s", id, response.Status)
+	}
+	return nil
+}
+
+// interruptKernel interrupts code running in kernel
+func interruptKernel(id string) error {
+	uri := fmt.Sprintf("%s/%s/interrupt", getKernelURI(), id)
+	response, err := http.Post(uri, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("Error interrupting kernel %s: %s", id, response.Status)
+	}
+	return nil
+}
+
+// GetKernel gets id of running kernel by name or starts kernel process
 func GetKernel() {
-	if !isKernelRunning(currentKernel.Name) {
-		startKernel(&currentKernel)
+	k := getCurrentKernel()
+	if running := runningKernel(k.Name); running != nil {
+		k.ID = running.ID
+	} else {
+		startKernel(&k)
 	}
+	setKernelID(k.ID)
 }
 
 // createMsg creates msg to be sent to kernel gateway
@@ -214,71 +228,79 @@ func createExecuteMsg(code string) *msg {
 	})
 }
 
-// handleWebSocket handles jupyter gateway websocket connection
-func handleWebSocket(ws *websocket.Conn, respCh chan string, errCh chan string) {
-	var err error
-	defer ws.Close()
-	for {
-		var respMsg msg
-		err = websocket.JSON.Receive(ws, &respMsg)
-		if err != nil {
-			if err == io.EOF {
-				ws.Close()
-				break
-			}
-			log.Printf("Error receiving message from websocket: %s", err)
-		} else {
-			go handleResponseMsg(&respMsg, respCh, errCh)
-		}
+// writeStream writes kernel stream message to runner stdout or stderr
+func writeStream(respMsg *msg) {
+	var out io.Writer
+	outMsg := respMsg.Content["text"].(string)
+	switch respMsg.Content["name"].(string) {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	}
+	_, err := fmt.Fprint(out, outMsg)
+	if err != nil {
+		log.Println(err)
 	}
 }
 
-// handleResponseMsg handles required types of jupyter messages
-func handleResponseMsg(respMsg *msg, resp chan string, errCh chan string) {
-	var err error
-	switch respMsg.Header.MsgType {
-	case "display_data", "execute_result":
-		data := respMsg.Content["data"].(map[string]interface{})
-		for _, v := range data {
-			resp <- v.(string)
-			break
-		}
-		break
-	case "stream":
-		var out io.Writer
-		outMsg := respMsg.Content["text"].(string)
-		switch respMsg.Content["name"].(string) {
-		case "stdout":
-			out = os.Stdout
-		case "stderr":
-			out = os.Stderr
-		}
-		_, err = fmt.Fprint(out, outMsg)
-		if err != nil {
-			log.Println(err)
-		}
-	case "error":
-		var buf bytes.Buffer
-		for _, v := range respMsg.Content["traceback"].([]interface{}) {
-			buf.WriteString(v.(string))
+// kernelError is exception raised by script in kernel
+type kernelError struct {
+	Name      string
+	Value     string
+	Traceback []string
+}
+
+// newKernelError creates kernel error from error message
+// with ANSI color codes removed from traceback
+func newKernelError(respMsg *msg) *kernelError {
+	ke := &kernelError{}
+	ke.Name, _ = respMsg.Content["ename"].(string)
+	ke.Value, _ = respMsg.Content["evalue"].(string)
+	lines, _ := respMsg.Content["traceback"].([]interface{})
+	for _, v := range lines {
+		line, _ := v.(string)
+		ke.Traceback = append(ke.Traceback, ansiRe.ReplaceAllString(line, ""))
+	}
+	return ke
+}
+
+func (ke *kernelError) Error() string {
+	if ke.Value == "" {
+		return ke.Name
+	}
+	return fmt.Sprintf("%s: %s", ke.Name, ke.Value)
+}
+
+func (ke *kernelError) stacktrace() string {
+	return strings.Join(ke.Traceback, "\n")
+}
+
+// isInputError checks if exception is caused by bad input data
+func isInputError(name string) bool {
+	return containsName(args.InputErrors, name)
+}
+
+// containsName checks if comma separated list contains name
+func containsName(list, name string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == name {
+			return true
 		}
-		errCh <- buf.String()
-		break
-	case "execute_reply":
-		resp <- ""
-		break
 	}
+	return false
 }
 
 // dialKernelWebSocket is a helper function to quick message sending
-func dialKernelWebSocket() *websocket.Conn {
-	if currentKernel.ID == "" {
+func dialKernelWebSocket() (*websocket.Conn, error) {
+	if getCurrentKernel().ID == "" {
 		GetKernel()
 	}
-	uri := fmt.Sprintf("%s/api/kernels/%s/channels", wsURI, currentKernel.ID)
-	ws, err := websocket.Dial(uri, "", baseURI)
-	if err != nil {
-		log.Fatalf("Error dialing websocket: %s", err)
-	}
-	return ws
+	return dialKernel(getCurrentKernel().ID)
+}
+
+// dialKernel opens websocket connection to kernel channels
+func dialKernel(id string) (*websocket.Conn, error) {
+	uri := fmt.Sprintf("%s/api/kernels/%s/channels", wsURI, id)
+	return websocket.Dial(uri, "", baseURI)
 }
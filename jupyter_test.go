@@ -2,25 +2,30 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"syscall"
+	"regexp"
 	"testing"
-	"time"
 
 	"golang.org/x/net/websocket"
 )
 
 func TestMain(m *testing.M) {
 	out = new(bytes.Buffer)
-	RunKernelGateway(out, out, "python")
-	time.Sleep(1 * time.Second)
+	err := RunKernelGateway(out, out, "python")
+	if err != nil {
+		log.Fatal(err)
+	}
 	GetKernel()
 	exitCode := m.Run()
 	shutdownCurrentKernel()
-	syscall.Kill(kgPID, syscall.SIGTERM)
+	gateway.Stop()
 	os.Exit(exitCode)
 }
 
@@ -59,13 +64,87 @@ func prepareScript(code string) error {
 //	}
 //}
 
+// hostilePayloads are request data which could break out of function call
+var hostilePayloads = []string{
+	`{"test": "test"}`,
+	`{"quote": "it's"}`,
+	`{"escape": "')\nimport os; os.system('id') #"}`,
+	`{"backslash": "C:\\path\\", "tab": "\t"}`,
+	"{\"multiline\":\r\n  [1,\n 2]}",
+	`{"unicode": "\u00e9\u4e2d\ud83d\ude00 ü"}`,
+	`"'''"`,
+	`[]`,
+}
+
+func TestFunctionCall_HostilePayloads(t *testing.T) {
+	callRe := regexp.MustCompile(`^test\(__import__\('base64'\)\.b64decode\('([A-Za-z0-9+/=]*)'\)\.decode\('utf-8'\)\)$`)
+	for _, payload := range hostilePayloads {
+		code, err := functionCall("test", []byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		match := callRe.FindStringSubmatch(code)
+		if match == nil {
+			t.Errorf("Unsafe function call generated: %s", code)
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(match[1])
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(decoded) != payload {
+			t.Errorf("Payload does not round-trip\nExpected: %s\nActual: %s\n", payload, decoded)
+		}
+	}
+}
+
+// TestFunctionCall_HostilePayloadsKernel checks that python kernel
+// passes payload to function byte for byte
+func TestFunctionCall_HostilePayloadsKernel(t *testing.T) {
+	if !isJupyterRunning() {
+		t.Skip("Kernel gateway is not running")
+	}
+	p, err := newKernelPool(1, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-p.sessions
+	defer s.Close()
+	p.sessions <- s
+	ctx := context.Background()
+	_, _, err = p.Run(ctx, "def echo(data):\n\treturn data.encode('utf-8').hex()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range hostilePayloads {
+		code, err := functionCall("echo", []byte(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := p.Run(ctx, code)
+		if err != nil {
+			t.Errorf("Error calling function with %s: %s", payload, err)
+			continue
+		}
+		expected := fmt.Sprintf("'%s'", hex.EncodeToString([]byte(payload)))
+		if res.text() != expected {
+			t.Errorf("Payload is changed by kernel\nExpected: %s\nActual: %s\n", expected, res.text())
+		}
+	}
+}
+
 func shutdownCurrentKernel() {
-	ws := dialKernelWebSocket()
+	ws, err := dialKernelWebSocket()
+	if err != nil {
+		log.Println("Kernel shutdown error", err)
+		return
+	}
 	defer ws.Close()
 	shutdownMsg := createMsg("shutdown_request", "shell", map[string]interface{}{
 		"restart": false,
 	})
-	err := websocket.JSON.Send(ws, &shutdownMsg)
+	err = websocket.JSON.Send(ws, &shutdownMsg)
 	if err != nil {
 		log.Println("Kernel shutdown error", err)
 	}
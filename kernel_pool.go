@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/websocket"
+)
+
+var (
+	pool    *kernelPool
+	metrics = expvar.NewMap("runner")
+
+	errSessionClosed = errors.New("Kernel connection closed")
+)
+
+// kernelPool is a set of kernels with preloaded scripts.
+// Every kernel runs one request at a time.
+type kernelPool struct {
+	size     int
+	sessions chan *session
+}
+
+// newKernelPool starts size kernels and runs scripts on every one of them.
+// Current kernel is reused as first pool kernel if reuse is set.
+func newKernelPool(size int, scripts []string, reuse bool) (*kernelPool, error) {
+	if size < 1 {
+		size = 1
+	}
+	p := &kernelPool{size: size, sessions: make(chan *session, size)}
+	current := getCurrentKernel()
+	for i := 0; i < size; i++ {
+		k := &kernel{Name: current.Name, ID: current.ID, Language: current.Language}
+		if i > 0 || k.ID == "" || !reuse {
+			started, err := createKernel(current.Name)
+			if err != nil {
+				return nil, err
+			}
+			k.ID = started.ID
+		}
+		s := &session{
+			kernel:  k,
+			scripts: scripts,
+			pending: map[string]*execution{},
+		}
+		err := s.connect()
+		if err != nil {
+			return nil, err
+		}
+		p.sessions <- s
+	}
+	return p, nil
+}
+
+// recover moves all pool sessions to new kernels,
+// used when kernel gateway was restarted
+func (p *kernelPool) recover() {
+	for i := 0; i < p.size; i++ {
+		s := <-p.sessions
+		err := s.restart()
+		if err != nil {
+			log.Printf("Error restarting kernel session: %s", err)
+		}
+		p.sessions <- s
+	}
+}
+
+// Run executes code on first available kernel
+func (p *kernelPool) Run(ctx context.Context, code string) (*result, time.Duration, error) {
+	duration := time.Duration(0)
+	var s *session
+	select {
+	case s = <-p.sessions:
+	case <-ctx.Done():
+		return &result{}, duration, ctx.Err()
+	}
+	defer func() { p.sessions <- s }()
+	start := time.Now().UTC()
+	res, err := s.run(ctx, code)
+	duration = time.Now().UTC().Sub(start) / time.Millisecond
+	if err == ctx.Err() && err != nil {
+		s.interrupt()
+	}
+	return res, duration, err
+}
+
+// session is persistent websocket connection to kernel.
+// Replies are routed to execute requests by parent message id.
+type session struct {
+	kernel  *kernel
+	scripts []string
+	ws      *websocket.Conn
+	closed  chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*execution
+}
+
+// execution receives replies to single execute request
+type execution struct {
+	msgs chan *msg
+	done chan struct{}
+}
+
+func openSession(ws *websocket.Conn) *session {
+	s := &session{pending: map[string]*execution{}}
+	s.attach(ws)
+	return s
+}
+
+// attach starts receiving messages from kernel connection
+func (s *session) attach(ws *websocket.Conn) {
+	s.ws = ws
+	s.closed = make(chan struct{})
+	go s.receive(ws, s.closed)
+}
+
+// connect opens connection to session kernel and loads session scripts
+func (s *session) connect() error {
+	ws, err := dialKernel(s.kernel.ID)
+	if err != nil {
+		return err
+	}
+	s.attach(ws)
+	for _, script := range s.scripts {
+		err = s.load(script)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restart restarts session kernel, or starts new one if kernel
+// is gone, and connects session to it
+func (s *session) restart() error {
+	if s.ws != nil {
+		s.Close()
+	}
+	err := restartKernel(s.kernel.ID)
+	if err != nil {
+		log.Println(err)
+		k, err := createKernel(s.kernel.Name)
+		if err != nil {
+			return err
+		}
+		s.kernel.ID = k.ID
+	}
+	return s.connect()
+}
+
+// recover restarts dead session kernel
+func (s *session) recover() error {
+	log.Printf("Restarting kernel %s", s.kernel.ID)
+	metrics.Add("kernel_restarts", 1)
+	err := s.restart()
+	if err != nil {
+		metrics.Add("kernel_restart_errors", 1)
+		log.Printf("Error restarting kernel: %s", err)
+		return err
+	}
+	log.Printf("Kernel restarted as %s", s.kernel.ID)
+	return nil
+}
+
+// run executes code in kernel. If kernel is dead it's restarted
+// and code is executed once again.
+func (s *session) run(ctx context.Context, code string) (*result, error) {
+	if s.isClosed() {
+		err := s.recover()
+		if err != nil {
+			return &result{}, err
+		}
+	}
+	res, err := s.execute(ctx, code)
+	if err != errSessionClosed {
+		return res, err
+	}
+	err = s.recover()
+	if err != nil {
+		return res, err
+	}
+	return s.execute(ctx, code)
+}
+
+func (s *session) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// load executes script file in kernel
+func (s *session) load(script string) error {
+	content, err := scriptContent(script)
+	if err != nil {
+		return err
+	}
+	_, err = s.execute(context.Background(), content)
+	if ke, ok := err.(*kernelError); ok {
+		log.Printf("Error loading script %s:\n%s", script, ke.stacktrace())
+	}
+	return err
+}
+
+// execute sends code to kernel and waits for its result.
+// Result is never nil, script exceptions are returned as *kernelError.
+func (s *session) execute(ctx context.Context, code string) (*result, error) {
+	req := createExecuteMsg(code)
+	ex := &execution{
+		msgs: make(chan *msg),
+		done: make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.pending[req.Header.MsgID] = ex
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, req.Header.MsgID)
+		s.mu.Unlock()
+		close(ex.done)
+	}()
+	res := &result{}
+	err := websocket.JSON.Send(s.ws, req)
+	if err != nil {
+		return res, err
+	}
+	listener := messageListener(ctx)
+	for !res.complete() {
+		select {
+		case respMsg := <-ex.msgs:
+			res.add(respMsg)
+			if listener != nil {
+				listener(respMsg)
+			}
+		case <-s.closed:
+			return res, errSessionClosed
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+	return res, res.err
+}
+
+// result collects messages produced by single execute request.
+// Execution is complete when kernel replied and became idle,
+// so all outputs of the request are received.
+type result struct {
+	bundle    map[string]interface{}
+	hasResult bool
+	err       error
+	idle      bool
+	replied   bool
+	stdout    outputBuffer
+	stderr    outputBuffer
+}
+
+func (r *result) add(respMsg *msg) {
+	switch respMsg.Header.MsgType {
+	case "execute_result":
+		r.bundle, _ = respMsg.Content["data"].(map[string]interface{})
+		r.hasResult = true
+	case "display_data":
+		if !r.hasResult && r.bundle == nil {
+			r.bundle, _ = respMsg.Content["data"].(map[string]interface{})
+		}
+	case "stream":
+		writeStream(respMsg)
+		text, _ := respMsg.Content["text"].(string)
+		switch respMsg.Content["name"] {
+		case "stdout":
+			r.stdout.write(text)
+		case "stderr":
+			r.stderr.write(text)
+		}
+	case "error":
+		r.err = newKernelError(respMsg)
+	case "status":
+		r.idle = respMsg.Content["execution_state"] == "idle"
+	case "execute_reply":
+		r.replied = true
+	}
+}
+
+func (r *result) complete() bool {
+	return r.idle && r.replied
+}
+
+// text returns plain text representation of result
+func (r *result) text() string {
+	text, _ := r.bundle["text/plain"].(string)
+	return text
+}
+
+// output returns stream output captured during execution
+func (r *result) output() *Output {
+	return &Output{
+		Stdout:    r.stdout.String(),
+		Stderr:    r.stderr.String(),
+		Truncated: r.stdout.truncated || r.stderr.truncated,
+	}
+}
+
+// outputBuffer keeps first args.MaxOutput bytes of stream output
+type outputBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *outputBuffer) write(text string) {
+	remaining := args.MaxOutput - b.Len()
+	if len(text) > remaining {
+		b.truncated = true
+		if remaining <= 0 {
+			return
+		}
+		for remaining > 0 && !utf8.RuneStart(text[remaining]) {
+			remaining--
+		}
+		text = text[:remaining]
+	}
+	b.WriteString(text)
+}
+
+// receive dispatches kernel messages to executions waiting for them
+func (s *session) receive(ws *websocket.Conn, closed chan struct{}) {
+	defer close(closed)
+	for {
+		var respMsg msg
+		err := websocket.JSON.Receive(ws, &respMsg)
+		if err != nil {
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				log.Printf("Error decoding kernel message: %s", err)
+				continue
+			}
+			if err != io.EOF {
+				log.Printf("Error receiving message from websocket: %s", err)
+			}
+			return
+		}
+		if respMsg.Header == nil {
+			continue
+		}
+		if isKernelDead(&respMsg) {
+			log.Printf("Kernel connection lost: kernel is %s", respMsg.Content["execution_state"])
+			ws.Close()
+			continue
+		}
+		if respMsg.ParentHeader == nil {
+			continue
+		}
+		s.mu.Lock()
+		ex, ok := s.pending[respMsg.ParentHeader.MsgID]
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case ex.msgs <- &respMsg:
+		case <-ex.done:
+		}
+	}
+}
+
+// isKernelDead checks if message reports kernel death or restart
+func isKernelDead(respMsg *msg) bool {
+	if respMsg.Header.MsgType != "status" {
+		return false
+	}
+	switch respMsg.Content["execution_state"] {
+	case "dead", "restarting":
+		return true
+	}
+	return false
+}
+
+// interrupt stops code running in kernel, so it's free for next request
+func (s *session) interrupt() {
+	log.Printf("Interrupting kernel %s", s.kernel.ID)
+	err := interruptKernel(s.kernel.ID)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Close closes kernel connection
+func (s *session) Close() error {
+	return s.ws.Close()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// mockKernel starts websocket server answering execute requests with handle
+func mockKernel(handle func(ws *websocket.Conn, req *msg)) (*httptest.Server, *session) {
+	ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		for {
+			var req msg
+			err := websocket.JSON.Receive(ws, &req)
+			if err != nil {
+				return
+			}
+			handle(ws, &req)
+		}
+	}))
+	uri := strings.Replace(ts.URL, "http", "ws", 1)
+	ws, err := websocket.Dial(uri, "", ts.URL)
+	if err != nil {
+		panic(err)
+	}
+	return ts, openSession(ws)
+}
+
+// withTestPool replaces kernel pool with single session pool,
+// returned function restores previous pool
+func withTestPool(s *session) (*kernelPool, func()) {
+	old := pool
+	pool = &kernelPool{size: 1, sessions: make(chan *session, 1)}
+	pool.sessions <- s
+	return pool, func() { pool = old }
+}
+
+func replyMsg(parent *msg, msgType string, content map[string]interface{}) *msg {
+	reply := createMsg(msgType, "iopub", content)
+	reply.ParentHeader = parent.Header
+	return reply
+}
+
+func executeResult(parent *msg, data string) *msg {
+	return replyMsg(parent, "execute_result", map[string]interface{}{
+		"data": map[string]interface{}{"text/plain": data},
+	})
+}
+
+// finish sends messages completing execution of request
+func finish(ws *websocket.Conn, req *msg) {
+	websocket.JSON.Send(ws, replyMsg(req, "execute_reply", map[string]interface{}{"status": "ok"}))
+	websocket.JSON.Send(ws, replyMsg(req, "status", map[string]interface{}{"execution_state": "idle"}))
+}
+
+func TestSession_Execute(t *testing.T) {
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		stray := createMsg("execute_request", "shell", nil)
+		finish(ws, stray)
+		websocket.JSON.Send(ws, executeResult(stray, "stray"))
+		websocket.JSON.Send(ws, replyMsg(req, "status", map[string]interface{}{"execution_state": "busy"}))
+		websocket.JSON.Send(ws, replyMsg(req, "execute_reply", map[string]interface{}{"status": "ok"}))
+		websocket.JSON.Send(ws, executeResult(req, req.Content["code"].(string)))
+		websocket.JSON.Send(ws, replyMsg(req, "status", map[string]interface{}{"execution_state": "idle"}))
+	})
+	defer ts.Close()
+	defer s.Close()
+	res, err := s.execute(context.Background(), "test()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := res.text(); data != "test()" {
+		t.Errorf("Wrong data\nExpected: %s\nActual: %s\n", "test()", data)
+	}
+}
+
+func TestSession_ExecuteConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	var received []*msg
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, req)
+		if len(received) < 2 {
+			return
+		}
+		// reply in reverse order
+		for i := len(received) - 1; i >= 0; i-- {
+			websocket.JSON.Send(ws, executeResult(received[i], received[i].Content["code"].(string)))
+			finish(ws, received[i])
+		}
+	})
+	defer ts.Close()
+	defer s.Close()
+	var wg sync.WaitGroup
+	for _, code := range []string{"first()", "second()"} {
+		wg.Add(1)
+		go func(code string) {
+			defer wg.Done()
+			res, err := s.execute(context.Background(), code)
+			if err != nil {
+				t.Error(err)
+			}
+			if data := res.text(); data != code {
+				t.Errorf("Reply is not correlated\nExpected: %s\nActual: %s\n", code, data)
+			}
+		}(code)
+	}
+	wg.Wait()
+}
+
+func TestSession_ExecuteError(t *testing.T) {
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		websocket.JSON.Send(ws, replyMsg(req, "error", map[string]interface{}{
+			"ename":     "NameError",
+			"evalue":    "name 'test' is not defined",
+			"traceback": []interface{}{"\x1b[0;31mNameError\x1b[0m: ", "name 'test' is not defined"},
+		}))
+		finish(ws, req)
+	})
+	defer ts.Close()
+	defer s.Close()
+	_, err := s.execute(context.Background(), "test()")
+	ke, ok := err.(*kernelError)
+	if !ok {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	if ke.Name != "NameError" || ke.Value != "name 'test' is not defined" {
+		t.Errorf("Wrong exception: %s", ke)
+	}
+	if len(ke.Traceback) != 2 || ke.Traceback[0] != "NameError: " {
+		t.Errorf("Wrong traceback: %q", ke.Traceback)
+	}
+}
+
+func TestSession_ExecuteTimeout(t *testing.T) {
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {})
+	defer ts.Close()
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.execute(ctx, "test()")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestKernelPool_RunTimeoutInterrupts(t *testing.T) {
+	interrupted := make(chan string, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		interrupted <- r.URL.Path
+	}))
+	defer gateway.Close()
+	defer func(uri string) { baseURI = uri }(baseURI)
+	baseURI = gateway.URL
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {})
+	defer ts.Close()
+	defer s.Close()
+	s.kernel = &kernel{Name: "python", ID: "test"}
+	p := &kernelPool{sessions: make(chan *session, 1)}
+	p.sessions <- s
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := p.Run(ctx, "while True: pass")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Wrong error: %v", err)
+	}
+	select {
+	case path := <-interrupted:
+		if path != "/api/kernels/test/interrupt" {
+			t.Errorf("Wrong interrupt path: %s", path)
+		}
+	default:
+		t.Error("Kernel is not interrupted")
+	}
+	if len(p.sessions) != 1 {
+		t.Error("Session is not released")
+	}
+}
+
+func TestSession_ExecuteCapturesOutput(t *testing.T) {
+	defer func(max int) { args.MaxOutput = max }(args.MaxOutput)
+	args.MaxOutput = 8
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		other := createMsg("execute_request", "shell", nil)
+		websocket.JSON.Send(ws, replyMsg(other, "stream", map[string]interface{}{"name": "stdout", "text": "other\n"}))
+		websocket.JSON.Send(ws, replyMsg(req, "stream", map[string]interface{}{"name": "stdout", "text": "line 1\n"}))
+		websocket.JSON.Send(ws, replyMsg(req, "stream", map[string]interface{}{"name": "stdout", "text": "line 2\n"}))
+		websocket.JSON.Send(ws, replyMsg(req, "stream", map[string]interface{}{"name": "stderr", "text": "warning\n"}))
+		finish(ws, req)
+	})
+	defer ts.Close()
+	defer s.Close()
+	res, err := s.execute(context.Background(), "test()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := res.output()
+	if output.Stdout != "line 1\nl" {
+		t.Errorf("Wrong stdout: %q", output.Stdout)
+	}
+	if output.Stderr != "warning\n" {
+		t.Errorf("Wrong stderr: %q", output.Stderr)
+	}
+	if !output.Truncated {
+		t.Error("Output is not marked as truncated")
+	}
+}
+
+func TestKernelPool_RunRestartsDeadKernel(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	restarts := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/kernels/test/restart", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		restarts++
+		mu.Unlock()
+	})
+	mux.Handle("/api/kernels/test/channels", websocket.Handler(func(ws *websocket.Conn) {
+		mu.Lock()
+		connections++
+		alive := connections > 1
+		mu.Unlock()
+		for {
+			var req msg
+			err := websocket.JSON.Receive(ws, &req)
+			if err != nil {
+				return
+			}
+			if !alive {
+				dead := createMsg("status", "iopub", map[string]interface{}{"execution_state": "restarting"})
+				websocket.JSON.Send(ws, dead)
+				continue
+			}
+			websocket.JSON.Send(ws, executeResult(&req, "'ok'"))
+			finish(ws, &req)
+		}
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer func(base, ws string) { baseURI, wsURI = base, ws }(baseURI, wsURI)
+	baseURI = ts.URL
+	wsURI = strings.Replace(ts.URL, "http", "ws", 1)
+
+	s := &session{kernel: &kernel{Name: "python", ID: "test"}, pending: map[string]*execution{}}
+	err := s.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p := &kernelPool{size: 1, sessions: make(chan *session, 1)}
+	p.sessions <- s
+	res, _, err := p.Run(context.Background(), "test()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.text() != "'ok'" {
+		t.Errorf("Wrong result after restart: %s", res.text())
+	}
+	if restarts != 1 || connections != 2 {
+		t.Errorf("Kernel is not restarted: %d restarts, %d connections", restarts, connections)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// kernelSpec is kernel available in kernel gateway
+// https://jupyter-client.readthedocs.io/en/latest/kernels.html#kernel-specs
+type kernelSpec struct {
+	Name string `json:"name"`
+	Spec struct {
+		Language    string `json:"language"`
+		DisplayName string `json:"display_name"`
+	} `json:"spec"`
+}
+
+// kernelSpecs is kernel gateway kernelspecs api response
+type kernelSpecs struct {
+	Default     string                 `json:"default"`
+	KernelSpecs map[string]*kernelSpec `json:"kernelspecs"`
+}
+
+// getKernelSpecs gets kernels available in kernel gateway
+func getKernelSpecs() (*kernelSpecs, error) {
+	resp, err := gatewayClient.Get(fmt.Sprintf("%s/api/kernelspecs", baseURI))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error getting kernelspecs: %s", resp.Status)
+	}
+	specs := &kernelSpecs{}
+	err = json.NewDecoder(resp.Body).Decode(specs)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding kernelspecs: %s", err)
+	}
+	return specs, nil
+}
+
+// find returns kernelspec by name. Language name is alias of kernel
+// with that language, e.g. python is python3, default kernel is preferred.
+func (ks *kernelSpecs) find(name string) (*kernelSpec, error) {
+	if spec, ok := ks.KernelSpecs[name]; ok {
+		return spec, nil
+	}
+	names := ks.names()
+	if _, ok := ks.KernelSpecs[ks.Default]; ok {
+		names = append([]string{ks.Default}, names...)
+	}
+	for _, n := range names {
+		spec := ks.KernelSpecs[n]
+		if strings.EqualFold(spec.Spec.Language, name) {
+			return spec, nil
+		}
+	}
+	return nil, fmt.Errorf("Kernel %s is not available, available kernels: %s",
+		name, strings.Join(ks.names(), ", "))
+}
+
+// names returns sorted kernel names
+func (ks *kernelSpecs) names() []string {
+	names := make([]string, 0, len(ks.KernelSpecs))
+	for name := range ks.KernelSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SelectKernel validates kernel name against kernelspecs and
+// sets currentKernel name and language
+func SelectKernel(name string) error {
+	specs, err := getKernelSpecs()
+	if err != nil {
+		return err
+	}
+	spec, err := specs.find(name)
+	if err != nil {
+		return err
+	}
+	language := strings.ToLower(spec.Spec.Language)
+	kernelMu.Lock()
+	currentKernel.Name = spec.Name
+	currentKernel.Language = language
+	kernelMu.Unlock()
+	_, err = callTemplate(language)
+	return err
+}
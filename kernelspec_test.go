@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const kernelSpecsJSON = `{
+	"default": "python3",
+	"kernelspecs": {
+		"python3": {"name": "python3", "spec": {"language": "python", "display_name": "Python 3"}},
+		"ir": {"name": "ir", "spec": {"language": "R", "display_name": "R"}},
+		"julia-1.9": {"name": "julia-1.9", "spec": {"language": "julia", "display_name": "Julia 1.9"}}
+	}
+}`
+
+func TestSelectKernel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/kernelspecs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(kernelSpecsJSON))
+	}))
+	defer ts.Close()
+	defer func(uri string, k kernel) { baseURI, currentKernel = uri, k }(baseURI, currentKernel)
+	baseURI = ts.URL
+	cases := []struct {
+		name     string
+		kernel   string
+		language string
+	}{
+		{"python3", "python3", "python"},
+		{"python", "python3", "python"},
+		{"r", "ir", "r"},
+		{"ir", "ir", "r"},
+		{"julia", "julia-1.9", "julia"},
+	}
+	for _, c := range cases {
+		err := SelectKernel(c.name)
+		if err != nil {
+			t.Errorf("Kernel %s: %s", c.name, err)
+			continue
+		}
+		if currentKernel.Name != c.kernel || currentKernel.Language != c.language {
+			t.Errorf("Kernel %s: wrong kernel selected: %s (%s)", c.name, currentKernel.Name, currentKernel.Language)
+		}
+	}
+	err := SelectKernel("scala")
+	if err == nil || !strings.Contains(err.Error(), "ir, julia-1.9, python3") {
+		t.Errorf("Wrong error for missing kernel: %v", err)
+	}
+}
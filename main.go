@@ -5,6 +5,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 var (
@@ -26,15 +28,84 @@ func main() {
 	flag.StringVar(&args.Script, "script", "", "Script to run")
 	flag.StringVar(&args.Function, "function", "", "Function to run")
 	flag.StringVar(&args.SecretKey, "secret", "", "Secret key")
+	flag.IntVar(&args.Kernels, "kernels", 1, "Number of kernels serving restful requests")
+	flag.IntVar(&args.BatchConcurrency, "batch-concurrency", 1, "Number of batch items executed at once")
+	flag.DurationVar(&args.RequestTimeout, "timeout", 30*time.Second, "Default restful request timeout")
+	flag.DurationVar(&args.MaxRequestTimeout, "max-timeout", 10*time.Minute, "Maximum restful request timeout")
+	flag.StringVar(&args.InputErrors, "input-errors", "ValueError,KeyError,TypeError",
+		"Comma separated exceptions reported as invalid input (422)")
+	flag.IntVar(&args.MaxOutput, "max-output", 64*1024, "Maximum size of captured stdout and stderr in bytes")
+	flag.StringVar(&args.GatewayHost, "gateway-host", "", "Kernel gateway host (default localhost)")
+	flag.IntVar(&args.GatewayPort, "gateway-port", 0, "Kernel gateway port (default 8888)")
+	flag.StringVar(&args.GatewayArgs, "gateway-args", "", "Extra kernel gateway command line arguments")
+	flag.BoolVar(&args.ExternalGateway, "external-gateway", false,
+		"Connect to externally managed kernel gateway instead of starting it")
+	flag.StringVar(&args.CallTemplate, "call-template", "",
+		"Function call template with {{.Function}} and base64 encoded {{.Data}} fields")
+	flag.StringVar(&args.Schedule, "schedule", "", "Cron expression of cron server runs, function runs once if empty")
+	flag.StringVar(&args.Overlap, "overlap", overlapSkip, "Policy of scheduled runs due while function is running: skip or queue")
+	flag.IntVar(&args.MaxAttempts, "max-attempts", 1, "Maximum number of attempts of cron server run")
+	flag.DurationVar(&args.RetryBackoff, "retry-backoff", time.Second, "Delay before first retry, doubled on every retry")
+	flag.StringVar(&args.RetryErrors, "retry-errors", "",
+		"Comma separated exceptions causing retry, any failure is retried if empty")
+	flag.StringVar(&args.FailureWebhook, "failure-webhook", "", "Url notified about runs failed after all attempts")
+	flag.DurationVar(&args.RunTimeout, "run-timeout", 0, "Cron server run timeout, runs are not limited if 0")
+	flag.StringVar(&args.ResultFile, "result-file", "result.json",
+		"Json file receiving result of run once job, relative to resource dir")
+	flag.DurationVar(&args.JobRetention, "job-retention", time.Hour, "Period async job results are kept for")
+	flag.StringVar(&args.CallbackURL, "callback-url", "", "Url notified about every finished cron server run")
+	flag.DurationVar(&args.IdempotencyTTL, "idempotency-ttl", defaultIdempotencyTTL, "Period responses are replayed for Idempotency-Key")
+	flag.IntVar(&args.CacheSize, "cache-size", 0, "Maximum number of cached restful results, caching is disabled if 0")
+	flag.DurationVar(&args.CacheTTL, "cache-ttl", 10*time.Minute, "Period restful results are cached for")
+	flag.BoolVar(&args.ValidateOutput, "validate-output", false, "Validate restful results against model output schema")
 	flag.Parse()
+	var err error
 	if args.KernelName == "" {
 		args.KernelName = os.Getenv("KERNEL_NAME")
 	}
 	if args.ServerType == "" {
 		args.ServerType = os.Getenv("SERVER_TYPE")
 	}
+	if args.GatewayHost == "" {
+		args.GatewayHost = os.Getenv("GATEWAY_HOST")
+	}
+	if port := os.Getenv("GATEWAY_PORT"); args.GatewayPort == 0 && port != "" {
+		args.GatewayPort, err = strconv.Atoi(port)
+		if err != nil {
+			logger.Fatalf("Invalid GATEWAY_PORT: %s", err)
+		}
+	}
+	if args.GatewayArgs == "" {
+		args.GatewayArgs = os.Getenv("GATEWAY_ARGS")
+	}
+	if external := os.Getenv("EXTERNAL_GATEWAY"); !args.ExternalGateway && external != "" {
+		args.ExternalGateway, err = strconv.ParseBool(external)
+		if err != nil {
+			logger.Fatalf("Invalid EXTERNAL_GATEWAY: %s", err)
+		}
+	}
+	if args.Schedule == "" {
+		args.Schedule = os.Getenv("SCHEDULE")
+	}
+	if args.CallbackURL == "" {
+		args.CallbackURL = os.Getenv("CALLBACK_URL")
+	}
+	if args.FailureWebhook == "" {
+		args.FailureWebhook = os.Getenv("FAILURE_WEBHOOK")
+	}
+	if args.SecretKey == "" && (args.CallbackURL != "" || args.FailureWebhook != "" || args.ServerType == "restful") {
+		logger.Printf("Secret key is not set, webhooks are sent without %s header", signatureHeader)
+	}
+	if args.CallTemplate == "" {
+		args.CallTemplate = os.Getenv("CALL_TEMPLATE")
+	}
+	err = SetCallTemplate(args.CallTemplate)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	ConfigureGateway(args.GatewayHost, args.GatewayPort, args.GatewayArgs, args.ExternalGateway)
 	SetKernelName(args.KernelName)
-	err := os.Chdir(args.ResourceDir)
+	err = os.Chdir(args.ResourceDir)
 	if err != nil {
 		logger.Fatal(err)
 	}
@@ -46,5 +117,9 @@ func main() {
 	if err != nil {
 		logger.Printf("[SSH tunnel]: %s", err)
 	}
-	logger.Fatal(getRunner(args.ServerType).Run())
+	err = getRunner(args.ServerType).Run()
+	if err != nil {
+		logger.Print(err)
+	}
+	os.Exit(exitCode(err))
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestName is models manifest file name in resource dir
+const manifestName = "models.json"
+
+var (
+	models = map[string]*model{}
+
+	errModelNotFound = errors.New("model not found")
+)
+
+// model binds model version to script and function serving it.
+// Request data and function result are validated against json schemas
+// if model has them, input.schema.json and output.schema.json are used by default.
+type model struct {
+	Name         string `json:"name,omitempty"`
+	Version      string `json:"version"`
+	Script       string `json:"script"`
+	Function     string `json:"function"`
+	InputSchema  string `json:"input_schema,omitempty"`
+	OutputSchema string `json:"output_schema,omitempty"`
+
+	input  *jsonSchema
+	output *jsonSchema
+	// pool is kernel pool with model script loaded
+	pool *kernelPool
+}
+
+// manifest is models manifest file format
+type manifest struct {
+	Models []*model `json:"models"`
+}
+
+// LoadModels reads models manifest from resource dir. If there is no manifest
+// script and function from command line are served as model version 1.0
+func LoadModels(resourceDir string) error {
+	loaded, err := readManifest(filepath.Join(resourceDir, manifestName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		loaded = []*model{{Version: "1.0", Script: args.Script, Function: args.Function}}
+	}
+	models = map[string]*model{}
+	modelVersions = map[string]bool{}
+	for _, m := range loaded {
+		err = m.loadSchemas(resourceDir)
+		if err != nil {
+			return err
+		}
+		models[modelKey(m.Name, m.Version)] = m
+		modelVersions[m.Version] = true
+	}
+	return nil
+}
+
+func readManifest(path string) ([]*model, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mf manifest
+	err = json.Unmarshal(data, &mf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", manifestName, err)
+	}
+	if len(mf.Models) == 0 {
+		return nil, fmt.Errorf("%s: no models defined", manifestName)
+	}
+	seen := map[string]bool{}
+	for _, m := range mf.Models {
+		switch {
+		case m.Version == "":
+			return nil, fmt.Errorf("%s: model version is required", manifestName)
+		case m.Script == "" || m.Function == "":
+			return nil, fmt.Errorf("%s: model %s requires script and function", manifestName, m.Version)
+		case seen[modelKey(m.Name, m.Version)]:
+			return nil, fmt.Errorf("%s: duplicate model %s version %s", manifestName, m.Name, m.Version)
+		}
+		seen[modelKey(m.Name, m.Version)] = true
+	}
+	return mf.Models, nil
+}
+
+// loadSchemas reads input and output schemas of model
+func (m *model) loadSchemas(resourceDir string) error {
+	var err error
+	m.input, err = loadSchema(resourceDir, m.InputSchema, inputSchemaName)
+	if err != nil {
+		return err
+	}
+	m.output, err = loadSchema(resourceDir, m.OutputSchema, outputSchemaName)
+	return err
+}
+
+// validateInput validates request data against model input schema
+func (m *model) validateInput(data []byte) error {
+	if m.input == nil {
+		return nil
+	}
+	err := m.input.Validate(data)
+	if err != nil {
+		return fmt.Errorf("data doesn't comply with schema: %s", err)
+	}
+	return nil
+}
+
+// outputError is function result not complying with model output schema
+type outputError struct {
+	err error
+}
+
+func (oe *outputError) Error() string {
+	return fmt.Sprintf("Script output doesn't comply with schema: %s", oe.err)
+}
+
+// run runs code on model kernels. If args.ValidateOutput is set, json
+// result is validated against model output schema and invalid result
+// is reported as *outputError.
+func (m *model) run(ctx context.Context, code string) (*result, time.Duration, error) {
+	res, duration, err := m.pool.Run(ctx, code)
+	if err != nil || m.output == nil || !args.ValidateOutput {
+		return res, duration, err
+	}
+	data, err := jsonOutput(res.bundle)
+	if err != nil {
+		// result conversion error is reported by caller
+		return res, duration, nil
+	}
+	err = m.output.Validate(data)
+	if err != nil {
+		return res, duration, &outputError{err}
+	}
+	return res, duration, nil
+}
+
+// modelKey returns models registry key of model version
+func modelKey(name, version string) string {
+	return name + "/" + version
+}
+
+// routeModel finds model serving request. Models are selected by
+// /models/{name}/{version} url path or by request model version
+// if it's served by single model.
+func routeModel(path string, r *Request) (*model, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return modelByVersion(r.ModelVersion)
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || parts[0] != "models" {
+		return nil, errModelNotFound
+	}
+	m, ok := models[modelKey(parts[1], parts[2])]
+	if !ok {
+		return nil, errModelNotFound
+	}
+	if r.ModelVersion != m.Version {
+		return nil, &requestError{
+			ModelVersionError: fmt.Sprintf("model_version %q does not match url version %q", r.ModelVersion, m.Version),
+			modelVersion:      r.ModelVersion,
+		}
+	}
+	return m, nil
+}
+
+// modelByVersion finds the only model with version
+func modelByVersion(version string) (*model, error) {
+	var found *model
+	for _, m := range models {
+		if m.Version != version {
+			continue
+		}
+		if found != nil {
+			return nil, &requestError{
+				ModelVersionError: fmt.Sprintf("model_version %q is served by several models, use /models/{name}/{version} url", version),
+				modelVersion:      version,
+			}
+		}
+		found = m
+	}
+	if found == nil {
+		return nil, errModelNotFound
+	}
+	return found, nil
+}
+
+// startPools starts kernel pool for every model script and assigns pools
+// to models. Scripts are loaded into separate kernels, so models defining
+// functions of the same name don't overwrite each other.
+func startPools(size int, newPool func(int, []string, bool) (*kernelPool, error)) ([]*kernelPool, error) {
+	started := map[string]*kernelPool{}
+	pools := []*kernelPool{}
+	for i, script := range modelScripts() {
+		p, err := newPool(size, []string{script}, i == 0)
+		if err != nil {
+			return nil, err
+		}
+		started[script] = p
+		pools = append(pools, p)
+	}
+	for _, m := range models {
+		m.pool = started[m.Script]
+	}
+	return pools, nil
+}
+
+// modelScripts returns scripts of all loaded models
+func modelScripts() []string {
+	seen := map[string]bool{}
+	scripts := []string{}
+	for _, m := range models {
+		if !seen[m.Script] {
+			seen[m.Script] = true
+			scripts = append(scripts, m.Script)
+		}
+	}
+	sort.Strings(scripts)
+	return scripts
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func prepareManifest(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, manifestName), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func resetModels() {
+	models = map[string]*model{}
+	modelVersions = map[string]bool{"1.0": true}
+}
+
+func TestLoadModels_Default(t *testing.T) {
+	defer resetModels()
+	args.Script = "test.py"
+	args.Function = "test"
+	err := LoadModels(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := models[modelKey("", "1.0")]
+	if !ok {
+		t.Fatal("Default model is not loaded")
+	}
+	if m.Script != args.Script || m.Function != args.Function {
+		t.Errorf("Wrong default model: %+v", m)
+	}
+}
+
+func TestLoadModels_Manifest(t *testing.T) {
+	defer resetModels()
+	dir := prepareManifest(t, `{"models": [
+		{"name": "iris", "version": "1.0", "script": "iris.py", "function": "predict"},
+		{"name": "iris", "version": "2.0", "script": "iris2.py", "function": "predict"}
+	]}`)
+	defer os.RemoveAll(dir)
+	err := LoadModels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modelVersions["1.0"] || !modelVersions["2.0"] {
+		t.Errorf("Model versions are not populated from manifest: %v", modelVersions)
+	}
+	m, err := routeModel("/", &Request{ModelVersion: "2.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Script != "iris2.py" {
+		t.Errorf("Wrong model script: %s", m.Script)
+	}
+	m, err = routeModel("/models/iris/1.0", &Request{ModelVersion: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Script != "iris.py" {
+		t.Errorf("Wrong model script: %s", m.Script)
+	}
+	_, err = routeModel("/models/iris/1.0", &Request{ModelVersion: "2.0"})
+	if _, ok := err.(*requestError); !ok {
+		t.Errorf("No request error on model version mismatch: %v", err)
+	}
+	_, err = routeModel("/models/wine/1.0", &Request{ModelVersion: "1.0"})
+	if err != errModelNotFound {
+		t.Errorf("Unknown model is routed: %v", err)
+	}
+}
+
+func TestLoadModels_DuplicateVersion(t *testing.T) {
+	defer resetModels()
+	dir := prepareManifest(t, `{"models": [
+		{"version": "1.0", "script": "a.py", "function": "predict"},
+		{"version": "1.0", "script": "b.py", "function": "predict"}
+	]}`)
+	defer os.RemoveAll(dir)
+	if LoadModels(dir) == nil {
+		t.Error("No error on duplicate model version")
+	}
+}
+
+func TestLoadModels_SharedVersion(t *testing.T) {
+	defer resetModels()
+	dir := prepareManifest(t, `{"models": [
+		{"name": "iris", "version": "1.0", "script": "iris.py", "function": "predict"},
+		{"name": "wine", "version": "1.0", "script": "wine.py", "function": "predict"},
+		{"name": "wine", "version": "2.0", "script": "wine2.py", "function": "predict"}
+	]}`)
+	defer os.RemoveAll(dir)
+	err := LoadModels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, script := range map[string]string{"/models/iris/1.0": "iris.py", "/models/wine/1.0": "wine.py"} {
+		m, err := routeModel(path, &Request{ModelVersion: "1.0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Script != script {
+			t.Errorf("%s is routed to %s", path, m.Script)
+		}
+	}
+	_, err = routeModel("/", &Request{ModelVersion: "1.0"})
+	if e, ok := err.(*requestError); !ok || e.ModelVersionError == "" {
+		t.Errorf("No request error for version of several models: %v", err)
+	}
+	m, err := routeModel("/", &Request{ModelVersion: "2.0"})
+	if err != nil || m.Script != "wine2.py" {
+		t.Errorf("Version of single model is not routed: %v", err)
+	}
+	_, err = routeModel("/models/iris/2.0", &Request{ModelVersion: "2.0"})
+	if err != errModelNotFound {
+		t.Errorf("Version of other model is routed: %v", err)
+	}
+}
+
+func TestStartPools(t *testing.T) {
+	defer resetModels()
+	dir := prepareManifest(t, `{"models": [
+		{"name": "iris", "version": "1.0", "script": "iris.py", "function": "predict"},
+		{"name": "iris", "version": "2.0", "script": "iris2.py", "function": "predict"}
+	]}`)
+	defer os.RemoveAll(dir)
+	err := LoadModels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused := 0
+	var closers []func() error
+	defer func() {
+		for _, close := range closers {
+			close()
+		}
+	}()
+	pools, err := startPools(1, func(size int, scripts []string, reuse bool) (*kernelPool, error) {
+		if reuse {
+			reused++
+		}
+		ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+			websocket.JSON.Send(ws, executeResult(req, scripts[0]))
+			finish(ws, req)
+		})
+		closers = append(closers, s.Close, func() error {
+			ts.Close()
+			return nil
+		})
+		p := &kernelPool{size: size, sessions: make(chan *session, 1)}
+		p.sessions <- s
+		return p, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 2 || reused != 1 {
+		t.Errorf("Wrong pools started: %d pools, %d reusing current kernel", len(pools), reused)
+	}
+	for version, script := range map[string]string{"1.0": "iris.py", "2.0": "iris2.py"} {
+		m, err := routeModel("/models/iris/"+version, &Request{ModelVersion: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, err := functionCall(m.Function, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := m.pool.Run(context.Background(), code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.text() != script {
+			t.Errorf("Model %s runs function of %s", version, res.text())
+		}
+	}
+}
+
+func TestLoadModels_Schemas(t *testing.T) {
+	defer resetModels()
+	dir := prepareManifest(t, `{"models": [
+		{"version": "1.0", "script": "iris.py", "function": "predict"},
+		{"version": "2.0", "script": "iris2.py", "function": "predict", "input_schema": "iris2.schema.json"}
+	]}`)
+	defer os.RemoveAll(dir)
+	schemas := map[string]string{
+		inputSchemaName:     `{"type": "object", "required": ["x"]}`,
+		"iris2.schema.json": `{"type": "array"}`,
+	}
+	for name, content := range schemas {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := LoadModels(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if models[modelKey("", "1.0")].output != nil {
+		t.Error("Output schema is loaded though there is no schema file")
+	}
+	if err := models[modelKey("", "1.0")].validateInput([]byte(`{"y": 1}`)); err == nil {
+		t.Error("Default input schema is not applied")
+	}
+	if err := models[modelKey("", "2.0")].validateInput([]byte(`[1]`)); err != nil {
+		t.Errorf("Model input schema is not applied: %s", err)
+	}
+	m := models[modelKey("", "2.0")]
+	m.OutputSchema = "missing.json"
+	err = m.loadSchemas(dir)
+	if err == nil {
+		t.Error("No error on missing output schema")
+	}
+}
+
+func TestModelRun_OutputSchema(t *testing.T) {
+	calls := 0
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		calls++
+		websocket.JSON.Send(ws, executeResult(req, "'text'"))
+		finish(ws, req)
+	})
+	defer ts.Close()
+	defer s.Close()
+	defer func(validate bool) { args.ValidateOutput = validate }(args.ValidateOutput)
+	args.ValidateOutput = true
+	results = newResultCache(10, time.Minute)
+	defer func() { results = nil }()
+	p, restore := withTestPool(s)
+	defer restore()
+	m := &model{Version: "1.0", Function: "test", pool: p, output: &jsonSchema{Type: schemaTypes{"number"}}}
+	_, _, err := m.run(context.Background(), "test()")
+	if _, ok := err.(*outputError); !ok {
+		t.Fatalf("No output error for invalid result: %v", err)
+	}
+	requestData := &Request{ModelVersion: "1.0", Data: json.RawMessage(`{}`)}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/", nil)
+		_, _, err = cachedRun(context.Background(), httptest.NewRecorder(), r, m, requestData, "test()", false)
+		if runError(err, &result{}, false).StatusCode != http.StatusInternalServerError {
+			t.Errorf("Wrong status of invalid result: %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Invalid result is cached: %d kernel executions", calls)
+	}
+	if resp := runResponse(context.Background(), m, "test()", requestData, false); resp.Status != "error" {
+		t.Errorf("Invalid result of async or stream request is returned: %+v", resp)
+	}
+	if resp := runBatchItem(context.Background(), m, &BatchItem{ID: "1", Data: json.RawMessage(`{}`)}, false); resp.Status != "error" {
+		t.Errorf("Invalid result of batch item is returned: %+v", resp)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonType is response type wrapping result into Response object
+const jsonType = "application/json"
+
+var (
+	// rawTypes are result mime types which could be returned as is
+	rawTypes = []string{"text/plain", "text/html", "image/png"}
+
+	errNotAcceptable = errors.New("Result is not available in any of accepted types")
+)
+
+// acceptedType is single media range of Accept header
+type acceptedType struct {
+	mimeType string
+	q        float64
+}
+
+// parseAccept returns media types of Accept header ordered by preference
+func parseAccept(header string) []string {
+	accepted := []acceptedType{}
+	for _, part := range strings.Split(header, ",") {
+		mimeType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil || q <= 0 {
+				continue
+			}
+		}
+		accepted = append(accepted, acceptedType{mimeType, q})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].q > accepted[j].q
+	})
+	types := make([]string, len(accepted))
+	for i, a := range accepted {
+		types[i] = a.mimeType
+	}
+	return types
+}
+
+// selectOutput returns mime type of result matching Accept header.
+// jsonType means result should be wrapped into Response object.
+func selectOutput(accept string, bundle map[string]interface{}) (string, error) {
+	types := parseAccept(accept)
+	if len(types) == 0 {
+		return jsonType, nil
+	}
+	for _, t := range types {
+		switch t {
+		case jsonType, "*/*", "application/*":
+			return jsonType, nil
+		}
+		for _, raw := range rawTypes {
+			if _, ok := bundle[raw]; ok && matchType(t, raw) {
+				return raw, nil
+			}
+		}
+	}
+	return "", errNotAcceptable
+}
+
+func matchType(pattern, mimeType string) bool {
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == mimeType
+}
+
+// rawOutput returns result of given mime type as response body
+func rawOutput(bundle map[string]interface{}, mimeType string) ([]byte, error) {
+	value, _ := bundle[mimeType].(string)
+	switch mimeType {
+	case "image/png":
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	case "text/plain":
+		if text, ok := unquoteRepr(value); ok {
+			return []byte(text), nil
+		}
+	}
+	return []byte(value), nil
+}
+
+// jsonOutput encodes result as json. Structured application/json results
+// are embedded as is, plain text results are used if they are valid json
+// or string literals containing json and encoded as json strings otherwise.
+func jsonOutput(bundle map[string]interface{}) (json.RawMessage, error) {
+	if value, ok := bundle[jsonType]; ok {
+		return json.Marshal(value)
+	}
+	text, ok := bundle["text/plain"].(string)
+	if !ok {
+		if len(bundle) == 0 {
+			return json.RawMessage("null"), nil
+		}
+		return json.Marshal(bundle)
+	}
+	if json.Valid([]byte(text)) {
+		return json.RawMessage(text), nil
+	}
+	if unquoted, ok := unquoteRepr(text); ok {
+		if json.Valid([]byte(unquoted)) {
+			return json.RawMessage(unquoted), nil
+		}
+		text = unquoted
+	}
+	return json.Marshal(text)
+}
+
+// unquoteRepr decodes string literal printed by kernel, e.g. python repr of str
+func unquoteRepr(s string) (string, bool) {
+	if len(s) < 2 {
+		return "", false
+	}
+	quote := s[0]
+	if (quote != '\'' && quote != '"') || s[len(s)-1] != quote {
+		return "", false
+	}
+	body := s[1 : len(s)-1]
+	if quote == '\'' {
+		body = strings.Replace(body, `\'`, `'`, -1)
+		body = strings.Replace(body, `"`, `\"`, -1)
+	}
+	unquoted, err := strconv.Unquote(`"` + body + `"`)
+	if err != nil {
+		return "", false
+	}
+	return unquoted, true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSelectOutput(t *testing.T) {
+	bundle := map[string]interface{}{
+		"text/plain": "<Figure>",
+		"image/png":  "iVBORw0KGgo=",
+	}
+	cases := []struct {
+		accept   string
+		expected string
+	}{
+		{"", jsonType},
+		{"*/*", jsonType},
+		{"application/json", jsonType},
+		{"image/png", "image/png"},
+		{"image/*", "image/png"},
+		{"text/html, text/plain;q=0.5", "text/plain"},
+		{"application/json;q=0.1, image/png", "image/png"},
+	}
+	for _, c := range cases {
+		mimeType, err := selectOutput(c.accept, bundle)
+		if err != nil {
+			t.Errorf("Accept %q: %s", c.accept, err)
+		}
+		if mimeType != c.expected {
+			t.Errorf("Accept %q: wrong mime type\nExpected: %s\nActual: %s\n", c.accept, c.expected, mimeType)
+		}
+	}
+	_, err := selectOutput("text/html", bundle)
+	if err != errNotAcceptable {
+		t.Errorf("No error for unavailable mime type: %v", err)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	cases := []struct {
+		bundle   map[string]interface{}
+		expected string
+	}{
+		{nil, `null`},
+		{map[string]interface{}{"application/json": map[string]interface{}{"a": 1}, "text/plain": "{'a': 1}"}, `{"a":1}`},
+		{map[string]interface{}{"text/plain": `{"a": 1}`}, `{"a": 1}`},
+		{map[string]interface{}{"text/plain": `'{"a": "it\'s"}'`}, `{"a": "it's"}`},
+		{map[string]interface{}{"text/plain": `'plain text'`}, `"plain text"`},
+		{map[string]interface{}{"text/plain": `Figure(640x480)`}, `"Figure(640x480)"`},
+	}
+	for _, c := range cases {
+		data, err := jsonOutput(c.bundle)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(data) != c.expected {
+			t.Errorf("Wrong json output\nExpected: %s\nActual: %s\n", c.expected, data)
+		}
+	}
+}
+
+func TestRawOutput(t *testing.T) {
+	bundle := map[string]interface{}{
+		"text/plain": `'line\nnext'`,
+		"image/png":  "iVBORw0KGgo=\n",
+	}
+	text, err := rawOutput(bundle, "text/plain")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(text) != "line\nnext" {
+		t.Errorf("Wrong text output: %q", text)
+	}
+	png, err := rawOutput(bundle, "image/png")
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Errorf("Wrong image output: %q", png)
+	}
+}
@@ -2,17 +2,294 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/handlers"
+)
+
+const (
+	// defaultRunsLimit is number of runs returned by RunsHandler by default
+	defaultRunsLimit = 20
+	maxRetryBackoff  = 10 * time.Minute
+)
+
+// exit codes of run once job
+const (
+	exitSuccess     = 0
+	exitScriptError = 1
+	exitInfraError  = 69
+	exitTimeout     = 124
 )
 
-type RunCode struct{}
+var history *runHistory
+
+// RunCode runs function once or on schedule if args.Schedule is set.
+// Runs are recorded in history, scheduled server reports them over http.
+type RunCode struct {
+	scheduler *scheduler
+}
 
 func (rp *RunCode) Run() error {
-	RunKernelGateway(out, out, args.KernelName)
-	GetKernel()
-	_, _, err := Run(context.Background(), args.Script, fmt.Sprintf("%s()", args.Function))
+	var err error
+	if args.Schedule != "" {
+		rp.scheduler, err = newScheduler(args.Schedule, args.Overlap, func(ctx context.Context) {
+			runWithRetries(ctx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	history = newRunHistory(args.ResourceDir)
+	err = rp.start()
+	if rp.scheduler == nil {
+		return runOnce(err)
+	}
 	if err != nil {
 		return err
 	}
+	gateway.OnRestart(pool.recover)
+	go rp.serveStatus()
+	return rp.scheduler.Run(context.Background())
+}
+
+// start starts kernel gateway and kernel with preloaded script
+func (rp *RunCode) start() error {
+	err := RunKernelGateway(out, out, args.KernelName)
+	if err != nil {
+		return err
+	}
+	GetKernel()
+	pool, err = newKernelPool(1, []string{args.Script}, true)
 	return err
 }
+
+// exitError is error terminating runner with exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode returns runner exit code for error returned by Runner
+func exitCode(err error) int {
+	if err == nil {
+		return exitSuccess
+	}
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	return 1
+}
+
+// runResult is result file of run once job
+type runResult struct {
+	ExitCode int             `json:"exit_code"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	*runRecord
+}
+
+// runOnce runs function once unless kernel failed to start,
+// writes result file and returns error with exit code
+func runOnce(startErr error) error {
+	err := startErr
+	rec := &runRecord{Status: runFailed}
+	if err == nil {
+		rec, err = runWithRetries(context.Background())
+	}
+	code := exitSuccess
+	switch err.(type) {
+	case nil:
+	case *kernelError:
+		code = exitScriptError
+	default:
+		code = exitInfraError
+		if err == context.DeadlineExceeded {
+			code = exitTimeout
+		}
+	}
+	if err != nil && rec.Error == "" {
+		rec.Error = err.Error()
+	}
+	resultErr := writeResult(&runResult{ExitCode: code, runRecord: rec})
+	if resultErr != nil {
+		log.Printf("Error writing result file: %s", resultErr)
+	}
+	if err == nil {
+		return nil
+	}
+	return &exitError{code, err}
+}
+
+// writeResult writes run result to args.ResultFile,
+// relative path is relative to resource dir
+func writeResult(res *runResult) error {
+	if args.ResultFile == "" {
+		return nil
+	}
+	if res.bundle != nil {
+		res.Data, _ = jsonOutput(res.bundle)
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	path := args.ResultFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(args.ResourceDir, path)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// serveStatus serves run status and history
+func (rp *RunCode) serveStatus() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", rp.StatusHandler)
+	mux.HandleFunc("/runs", RunsHandler)
+	server := &http.Server{
+		Addr:        ":6006",
+		ReadTimeout: 10 * time.Second,
+		Handler:     handlers.LoggingHandler(out, mux),
+	}
+	log.Println(server.ListenAndServe())
+}
+
+// runWithRetries runs function until it succeeds, fails with error which
+// is not retried or args.MaxAttempts is reached. Delay between attempts
+// grows exponentially. Failure webhook is notified about final failure
+// and callback about final result.
+func runWithRetries(ctx context.Context) (*runRecord, error) {
+	backoff := args.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		rec, err := runFunction(ctx, attempt)
+		if err == nil {
+			notifyFinished(rec)
+			return rec, nil
+		}
+		if attempt >= args.MaxAttempts || !isRetryable(err) {
+			notifyFailure(rec)
+			notifyFinished(rec)
+			return rec, err
+		}
+		log.Printf("Retrying run in %s, attempt %d of %d", backoff, attempt+1, args.MaxAttempts)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return rec, ctx.Err()
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// isRetryable checks if run failed with error listed in args.RetryErrors.
+// Any error is retried if list is empty.
+func isRetryable(err error) bool {
+	if args.RetryErrors == "" {
+		return true
+	}
+	ke, ok := err.(*kernelError)
+	return ok && containsName(args.RetryErrors, ke.Name)
+}
+
+// runFunction runs function on warm kernel, logs and records run result
+func runFunction(ctx context.Context, attempt int) (*runRecord, error) {
+	if args.RunTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.RunTimeout)
+		defer cancel()
+	}
+	start := time.Now().UTC()
+	res := &result{}
+	code, err := functionCall(args.Function, nil)
+	if err == nil {
+		res, _, err = pool.Run(ctx, code)
+	}
+	rec := newRunRecord(start, res, err)
+	rec.Attempt = attempt
+	if err != nil {
+		log.Printf("Run failed in %dms: %s", rec.Duration, err)
+		if ke, ok := err.(*kernelError); ok && len(ke.Traceback) > 0 {
+			log.Println(ke.stacktrace())
+		}
+	} else {
+		log.Printf("Run succeeded in %dms: %s", rec.Duration, rec.Result)
+	}
+	err = history.Append(rec)
+	if err != nil {
+		log.Printf("Error recording run: %s", err)
+	}
+	return rec, rec.err
+}
+
+// StatusHandler writes last runs and next scheduled run
+func (rp *RunCode) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkStatusRequest(w, r) {
+		return
+	}
+	status, err := history.Status()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status.Schedule = args.Schedule
+	status.Running = rp.scheduler.isRunning()
+	if next := rp.scheduler.nextRun(); !next.IsZero() {
+		status.NextRun = &next
+	}
+	writeJSON(w, status)
+}
+
+// RunsHandler writes recent runs, newest first
+func RunsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkStatusRequest(w, r) {
+		return
+	}
+	limit := defaultRunsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	runs, err := history.Recent(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"runs": runs})
+}
+
+// checkStatusRequest writes error response unless request is authorized GET
+func checkStatusRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	if !checkToken(args.ApiRoot, r.URL.Query().Get("access_token")) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
 )
 
 func TestCodeRun(t *testing.T) {
@@ -16,3 +24,162 @@ func TestCodeRun(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// mockRetries sets pool kernel failing with ename exception failures times
+func mockRetries(t *testing.T, failures int, ename string) func() {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history = newRunHistory(dir)
+	attempts := 0
+	ts, s := mockKernel(func(ws *websocket.Conn, req *msg) {
+		attempts++
+		if attempts <= failures {
+			websocket.JSON.Send(ws, replyMsg(req, "error", map[string]interface{}{"ename": ename, "evalue": "failed"}))
+		} else {
+			websocket.JSON.Send(ws, executeResult(req, "'ok'"))
+		}
+		finish(ws, req)
+	})
+	_, restore := withTestPool(s)
+	return func() {
+		restore()
+		s.Close()
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRunWithRetries(t *testing.T) {
+	cleanup := mockRetries(t, 2, "ValueError")
+	defer cleanup()
+	args.MaxAttempts = 3
+	args.RetryBackoff = time.Millisecond
+	args.RetryErrors = "ValueError"
+	rec, err := runWithRetries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != runSucceeded || rec.Attempt != 3 {
+		t.Errorf("Wrong run record: %+v", rec)
+	}
+	runs, _ := history.Recent(10)
+	if len(runs) != 3 || runs[2].Status != runFailed {
+		t.Errorf("Attempts are not recorded: %+v", runs)
+	}
+}
+
+func TestRunWithRetries_NotRetryable(t *testing.T) {
+	events := make(chan *runEvent, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &runEvent{}
+		json.NewDecoder(r.Body).Decode(event)
+		events <- event
+	}))
+	defer webhook.Close()
+	cleanup := mockRetries(t, 2, "KeyError")
+	defer cleanup()
+	args.MaxAttempts = 3
+	args.RetryBackoff = time.Millisecond
+	args.RetryErrors = "ValueError"
+	args.FailureWebhook = webhook.URL
+	defer func() { args.FailureWebhook = "" }()
+	rec, err := runWithRetries(context.Background())
+	if ke, ok := err.(*kernelError); !ok || ke.Name != "KeyError" {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	if rec.Attempt != 1 {
+		t.Errorf("Not retryable error is retried: %d attempts", rec.Attempt)
+	}
+	select {
+	case event := <-events:
+		if event.Event != "run_failed" || event.Run.Error != "KeyError: failed" {
+			t.Errorf("Wrong failure event: %+v", event)
+		}
+	default:
+		t.Error("Failure webhook is not notified")
+	}
+}
+
+func TestRunWithRetries_Callback(t *testing.T) {
+	responses := make(chan *Response, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &Response{}
+		json.NewDecoder(r.Body).Decode(resp)
+		responses <- resp
+	}))
+	defer receiver.Close()
+	args.CallbackURL = receiver.URL
+	defer func() { args.CallbackURL = "" }()
+	args.MaxAttempts = 1
+	args.RetryErrors = ""
+	cases := []struct {
+		failures int
+		status   string
+		data     string
+		ename    string
+	}{
+		{0, "ok", `"ok"`, ""},
+		{1, "error", ``, "ValueError"},
+	}
+	for _, c := range cases {
+		cleanup := mockRetries(t, c.failures, "ValueError")
+		runWithRetries(context.Background())
+		cleanup()
+		select {
+		case resp := <-responses:
+			if resp.Status != c.status || string(resp.Data) != c.data || resp.Ename != c.ename {
+				t.Errorf("Wrong callback response: %+v", resp)
+			}
+			if resp.SchemaVersion == "" || resp.Timestamp.IsZero() {
+				t.Errorf("Callback response is incomplete: %+v", resp)
+			}
+		default:
+			t.Error("Callback is not notified")
+		}
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	f, err := ioutil.TempFile("", "result")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer func(path string) { args.ResultFile = path }(args.ResultFile)
+	args.ResultFile = f.Name()
+	args.MaxAttempts = 1
+	args.RetryErrors = ""
+	cases := []struct {
+		failures int
+		startErr error
+		code     int
+		data     string
+	}{
+		{0, nil, exitSuccess, `"ok"`},
+		{1, nil, exitScriptError, ``},
+		{0, errSessionClosed, exitInfraError, ``},
+	}
+	for _, c := range cases {
+		cleanup := mockRetries(t, c.failures, "ValueError")
+		err := runOnce(c.startErr)
+		cleanup()
+		if exitCode(err) != c.code {
+			t.Errorf("Wrong exit code: %d, %v", exitCode(err), err)
+		}
+		data, _ := ioutil.ReadFile(f.Name())
+		res := &runResult{runRecord: &runRecord{}}
+		err = json.Unmarshal(data, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ExitCode != c.code || string(res.Data) != c.data {
+			t.Errorf("Wrong result file: %s", data)
+		}
+		if c.code != exitSuccess && res.Error == "" {
+			t.Errorf("No error in result file: %s", data)
+		}
+	}
+}
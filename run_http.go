@@ -4,41 +4,259 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"regexp"
-
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
 )
 
-const requestTimeout = 30 * time.Second
+const (
+	batchSuffix = "/batch"
+	metricsPath = "/metrics"
+	// captureParam enables capturing of script stdout and stderr
+	captureParam = "capture_output"
+	// timeoutHeader overrides default request timeout,
+	// value is number of seconds or duration like 1m30s
+	timeoutHeader = "X-Request-Timeout"
+)
 
 type RunHTTP struct{}
 
 func (rh *RunHTTP) Run() error {
-	RunKernelGateway(out, out, args.KernelName)
+	err := LoadModels(args.ResourceDir)
+	if err != nil {
+		return err
+	}
+	err = RunKernelGateway(out, out, args.KernelName)
+	if err != nil {
+		return err
+	}
 	GetKernel()
+	pools, err := startPools(args.Kernels, newKernelPool)
+	if err != nil {
+		return err
+	}
+	gateway.OnRestart(func() {
+		for _, p := range pools {
+			p.recover()
+		}
+	})
+	if args.CacheSize > 0 {
+		results = newResultCache(args.CacheSize, args.CacheTTL)
+	}
 	server := &http.Server{
 		Addr:        ":6006",
 		ReadTimeout: 10 * time.Second,
-		Handler:     handlers.LoggingHandler(out, http.HandlerFunc(ScriptHandler)),
+		Handler:     handlers.LoggingHandler(out, http.HandlerFunc(RouteHandler)),
 	}
 	return server.ListenAndServe()
 }
 
-func ScriptHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
-	defer cancel()
+// RouteHandler dispatches batch requests to BatchHandler, streaming
+// requests to StreamHandler, job requests to JobHandler, metrics
+// and cache requests to their handlers and all other requests
+// to ScriptHandler
+func RouteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == metricsPath {
+		MetricsHandler(w, r)
+		return
+	}
+	if r.URL.Path == cachePath {
+		CacheHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, jobsPath) {
+		JobHandler(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, streamSuffix) {
+		StreamHandler(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, batchSuffix) {
+		idempotent(BatchHandler)(w, r)
+		return
+	}
+	idempotent(ScriptHandler)(w, r)
+}
+
+// MetricsHandler writes runner metrics as json
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkToken(args.ApiRoot, r.URL.Query().Get("access_token")) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintln(w, metrics.String())
+}
+
+// checkRequest writes error response if request method or token is invalid
+func checkRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
 	if r.Method != "POST" {
 		appErr := AppError{StatusCode: http.StatusMethodNotAllowed}
 		appErr.Write(ctx, w)
-		return
+		return false
 	}
 	if !checkToken(args.ApiRoot, r.URL.Query().Get("access_token")) {
 		appErr := AppError{
 			StatusCode: http.StatusForbidden,
 		}
 		appErr.Write(ctx, w)
+		return false
+	}
+	if state := gateway.State(); state != gatewayReady {
+		appErr := AppError{
+			StatusCode: http.StatusServiceUnavailable,
+			Reason:     fmt.Sprintf("Kernel gateway is %s", state),
+		}
+		appErr.Write(ctx, w)
+		return false
+	}
+	return true
+}
+
+func writeRouteError(ctx context.Context, w http.ResponseWriter, err error, r *Request) {
+	appErr := AppError{Err: err, StatusCode: http.StatusBadRequest, ModelVersion: r.ModelVersion}
+	if err == errModelNotFound {
+		appErr.StatusCode = http.StatusNotFound
+	}
+	appErr.Write(ctx, w)
+}
+
+// checkData writes error response if request data doesn't comply with model input schema
+func checkData(ctx context.Context, w http.ResponseWriter, m *model, data []byte) bool {
+	err := m.validateInput(data)
+	if err != nil {
+		appErr := AppError{
+			Err:          &requestError{DataError: err.Error()},
+			StatusCode:   http.StatusBadRequest,
+			ModelVersion: m.Version,
+		}
+		appErr.Write(ctx, w)
+		return false
+	}
+	return true
+}
+
+// checkCallback writes error response if request which is not async has
+// callback url, callback is notified about async job results only
+func checkCallback(ctx context.Context, w http.ResponseWriter, r *Request, async bool) bool {
+	if r.CallbackURL == "" || async {
+		return true
+	}
+	appErr := AppError{
+		Err:          &requestError{CallbackURLError: "callback_url is supported by async requests only"},
+		StatusCode:   http.StatusBadRequest,
+		ModelVersion: r.ModelVersion,
+	}
+	appErr.Write(ctx, w)
+	return false
+}
+
+// requestTimeout returns timeout of request, timeout
+// from header is limited to args.MaxRequestTimeout
+func requestTimeout(r *http.Request) (time.Duration, error) {
+	header := r.Header.Get(timeoutHeader)
+	if header == "" {
+		return args.RequestTimeout, nil
+	}
+	timeout, err := time.ParseDuration(header)
+	if err != nil {
+		seconds, convErr := strconv.ParseFloat(header, 64)
+		if convErr != nil {
+			return args.RequestTimeout, fmt.Errorf("%s: invalid timeout %q", timeoutHeader, header)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return args.RequestTimeout, fmt.Errorf("%s: timeout must be positive", timeoutHeader)
+	}
+	if args.MaxRequestTimeout > 0 && timeout > args.MaxRequestTimeout {
+		timeout = args.MaxRequestTimeout
+	}
+	return timeout, nil
+}
+
+// requestContext creates context with request timeout
+// and writes error response if timeout is invalid
+func requestContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc, bool) {
+	timeout, err := requestTimeout(r)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusBadRequest}
+		appErr.Write(ctx, w)
+		return ctx, cancel, false
+	}
+	return ctx, cancel, true
+}
+
+// captureOutput checks if script output is requested
+func captureOutput(r *http.Request) bool {
+	capture, _ := strconv.ParseBool(r.URL.Query().Get(captureParam))
+	return capture
+}
+
+// runError converts kernel run error to app error. Script exceptions are
+// client errors, kernel connection and restart failures are bad gateway errors.
+func runError(err error, res *result, capture bool) *AppError {
+	appErr := &AppError{
+		Err:        err,
+		StatusCode: http.StatusBadGateway,
+	}
+	switch e := err.(type) {
+	case *kernelError:
+		appErr.StatusCode = http.StatusBadRequest
+		if isInputError(e.Name) {
+			appErr.StatusCode = http.StatusUnprocessableEntity
+		}
+	case *outputError:
+		appErr.StatusCode = http.StatusInternalServerError
+	}
+	if capture {
+		appErr.Output = res.output()
+	}
+	if err == context.DeadlineExceeded {
+		appErr.StatusCode = http.StatusGatewayTimeout
+		appErr.Reason = "Script execution timed out"
+	}
+	if err == context.Canceled {
+		appErr.StatusCode = http.StatusServiceUnavailable
+		appErr.Reason = "Script execution was cancelled"
+	}
+	return appErr
+}
+
+// runResponse runs code on model kernels and creates json response with its result
+func runResponse(ctx context.Context, m *model, code string, requestData *Request, capture bool) *Response {
+	res, duration, err := m.run(ctx, code)
+	if err != nil {
+		appErr := runError(err, res, capture)
+		appErr.ModelVersion = m.Version
+		return appErr.Response()
+	}
+	resp := CreateResponseFromRequest(requestData)
+	resp.Data, err = jsonOutput(res.bundle)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError, ModelVersion: m.Version}
+		return appErr.Response()
+	}
+	resp.Status = "ok"
+	resp.ExecutionTime = duration
+	if capture {
+		resp.Output = res.output()
+	}
+	return resp
+}
+
+func ScriptHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(context.Background(), w, r) {
+		return
+	}
+	ctx, cancel, ok := requestContext(w, r)
+	defer cancel()
+	if !ok {
 		return
 	}
 	var requestData *Request
@@ -49,22 +267,166 @@ func ScriptHandler(w http.ResponseWriter, r *http.Request) {
 		appErr.Write(ctx, w)
 		return
 	}
+	if !checkCallback(ctx, w, requestData, isAsync(r)) {
+		return
+	}
+	m, err := routeModel(r.URL.Path, requestData)
+	if err != nil {
+		writeRouteError(ctx, w, err, requestData)
+		return
+	}
+	if !checkData(ctx, w, m, requestData.Data) {
+		return
+	}
+	code, err := functionCall(m.Function, requestData.Data)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError, ModelVersion: m.Version}
+		appErr.Write(ctx, w)
+		return
+	}
+	capture := captureOutput(r)
+	if isAsync(r) {
+		writeJob(ctx, w, http.StatusAccepted, startJob(r, m, code, requestData, capture))
+		return
+	}
 	resp := CreateResponseFromRequest(requestData)
-	re := regexp.MustCompile(`\r?\n`)
-	rawCode := fmt.Sprintf(`%s('%s')`, args.Function, requestData.DataString())
-	code := re.ReplaceAllString(rawCode, "")
-	data, duration, err := Run(ctx, args.Script, code)
+	res, duration, err := cachedRun(ctx, w, r, m, requestData, code, capture)
 	if err != nil {
-		appErr := AppError{
-			Err:        err,
-			StatusCode: http.StatusBadRequest,
-			Stacktrace: data,
-		}
+		appErr := runError(err, res, capture)
+		appErr.ModelVersion = m.Version
+		appErr.Write(ctx, w)
+		return
+	}
+	mimeType, err := selectOutput(r.Header.Get("Accept"), res.bundle)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusNotAcceptable, ModelVersion: m.Version}
+		appErr.Write(ctx, w)
+		return
+	}
+	if mimeType != jsonType {
+		writeRawOutput(ctx, w, res.bundle, mimeType)
+		return
+	}
+	resp.Data, err = jsonOutput(res.bundle)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError, ModelVersion: m.Version}
 		appErr.Write(ctx, w)
 		return
 	}
 	resp.Status = "ok"
 	resp.ExecutionTime = duration
-	resp.Data = []byte(data)
+	if capture {
+		resp.Output = res.output()
+	}
 	resp.Write(ctx, w)
 }
+
+// writeRawOutput writes result of given mime type as response body
+func writeRawOutput(ctx context.Context, w http.ResponseWriter, bundle map[string]interface{}, mimeType string) {
+	body, err := rawOutput(bundle, mimeType)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError}
+		appErr.Write(ctx, w)
+		return
+	}
+	if strings.HasPrefix(mimeType, "text/") {
+		mimeType += "; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(body)
+}
+
+// BatchHandler runs model function for every item of batch request.
+// Failed items are reported in their own responses and don't fail the batch.
+func BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(context.Background(), w, r) {
+		return
+	}
+	ctx, cancel, ok := requestContext(w, r)
+	defer cancel()
+	if !ok {
+		return
+	}
+	requestData, err := BuildBatchRequest(r.Body)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusBadRequest}
+		appErr.Write(ctx, w)
+		return
+	}
+	if !checkCallback(ctx, w, &requestData.Request, false) {
+		return
+	}
+	m, err := routeModel(strings.TrimSuffix(r.URL.Path, batchSuffix), &requestData.Request)
+	if err != nil {
+		writeRouteError(ctx, w, err, &requestData.Request)
+		return
+	}
+	resp := CreateResponseFromRequest(&requestData.Request)
+	start := time.Now().UTC()
+	resp.Items = runBatch(ctx, m, requestData.Items, captureOutput(r))
+	resp.Status = "ok"
+	resp.ExecutionTime = time.Now().UTC().Sub(start) / time.Millisecond
+	resp.Write(ctx, w)
+}
+
+// runBatch runs batch items with at most args.BatchConcurrency items at once
+func runBatch(ctx context.Context, m *model, items []*BatchItem, capture bool) []*ItemResponse {
+	concurrency := args.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	results := make([]*ItemResponse, len(items))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item *BatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runBatchItem(ctx, m, item, capture)
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
+
+func runBatchItem(ctx context.Context, m *model, item *BatchItem, capture bool) *ItemResponse {
+	resp := &ItemResponse{ID: item.ID, Status: "error"}
+	if ctx.Err() != nil {
+		resp.Reason = ctx.Err().Error()
+		return resp
+	}
+	if err := m.validateInput(item.Data); err != nil {
+		resp.Reason = err.Error()
+		return resp
+	}
+	code, err := functionCall(m.Function, item.Data)
+	if err != nil {
+		resp.Reason = err.Error()
+		return resp
+	}
+	res, _, err := m.run(ctx, code)
+	if capture {
+		resp.Output = res.output()
+	}
+	if err != nil {
+		appErr := runError(err, res, false)
+		resp.Reason = appErr.Error()
+		if ke, ok := err.(*kernelError); ok {
+			resp.Ename = ke.Name
+			resp.Evalue = ke.Value
+			resp.Traceback = ke.Traceback
+			resp.Stacktrace = ke.stacktrace()
+		}
+		return resp
+	}
+	resp.Data, err = jsonOutput(res.bundle)
+	if err != nil {
+		resp.Reason = err.Error()
+		return resp
+	}
+	resp.Status = "ok"
+	return resp
+}
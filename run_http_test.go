@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	args.RequestTimeout = 30 * time.Second
+	args.MaxRequestTimeout = time.Minute
+	cases := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"5", 5 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{"100ms", 100 * time.Millisecond},
+		{"1h", time.Minute},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/", nil)
+		if c.header != "" {
+			r.Header.Set(timeoutHeader, c.header)
+		}
+		timeout, err := requestTimeout(r)
+		if err != nil {
+			t.Error(err)
+		}
+		if timeout != c.expected {
+			t.Errorf("Wrong timeout for %q\nExpected: %s\nActual: %s\n", c.header, c.expected, timeout)
+		}
+	}
+	for _, header := range []string{"soon", "-1", "0"} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set(timeoutHeader, header)
+		_, err := requestTimeout(r)
+		if err == nil {
+			t.Errorf("No error for timeout %q", header)
+		}
+	}
+}
+
+func TestHandlers_AuthBeforeTimeout(t *testing.T) {
+	for _, handler := range []http.HandlerFunc{ScriptHandler, BatchHandler, StreamHandler} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set(timeoutHeader, "soon")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Unauthenticated request with invalid timeout got %d", w.Code)
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+	args.InputErrors = "ValueError,KeyError"
+	ke := &kernelError{
+		Name:      "ValueError",
+		Value:     "bad input",
+		Traceback: []string{"Traceback", "ValueError: bad input"},
+	}
+	appErr := runError(ke, &result{}, false)
+	if appErr.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Wrong status code for input error: %d", appErr.StatusCode)
+	}
+	w := httptest.NewRecorder()
+	appErr.Write(context.Background(), w)
+	resp := Response{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ename != ke.Name || resp.Evalue != ke.Value || len(resp.Traceback) != 2 {
+		t.Errorf("Exception details are not included in response: %+v", resp)
+	}
+	ke.Name = "ZeroDivisionError"
+	appErr = runError(ke, &result{}, false)
+	if appErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrong status code for script error: %d", appErr.StatusCode)
+	}
+	appErr = runError(context.DeadlineExceeded, &result{}, false)
+	if appErr.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Wrong status code for timeout: %d", appErr.StatusCode)
+	}
+	appErr = runError(context.Canceled, &result{}, false)
+	if appErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Wrong status code for cancelled execution: %d", appErr.StatusCode)
+	}
+	appErr = runError(errSessionClosed, &result{}, false)
+	if appErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Wrong status code for closed kernel connection: %d", appErr.StatusCode)
+	}
+	appErr = runError(fmt.Errorf("Error restarting kernel test: 500 Internal Server Error"), &result{}, false)
+	if appErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Wrong status code for kernel restart error: %d", appErr.StatusCode)
+	}
+}
+
+func TestCheckCallback(t *testing.T) {
+	requestData := &Request{ModelVersion: "1.0", CallbackURL: "http://example.com/callback"}
+	w := httptest.NewRecorder()
+	if !checkCallback(context.Background(), w, requestData, true) {
+		t.Error("Callback of async request is rejected")
+	}
+	if checkCallback(context.Background(), w, requestData, false) {
+		t.Fatal("Callback of sync request is accepted")
+	}
+	resp := Response{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || resp.RequestError == nil || resp.RequestError.CallbackURLError == "" {
+		t.Errorf("Wrong response to sync request with callback: %d %+v", w.Code, resp)
+	}
+	if !checkCallback(context.Background(), httptest.NewRecorder(), &Request{}, false) {
+		t.Error("Sync request without callback is rejected")
+	}
+}
@@ -73,7 +73,7 @@ func (rp *RunProxy) Run() error {
 	if err != nil {
 		return err
 	}
-	targetURL, _ := url.Parse("http://localhost:8888")
+	targetURL, _ := url.Parse(baseURI)
 	proxy := &httputil.ReverseProxy{
 		Transport: &Transport{http.DefaultTransport},
 		Director: func(req *http.Request) {
@@ -144,7 +144,7 @@ func handleWS(w http.ResponseWriter, r *http.Request) error {
 
 func hijack(w http.ResponseWriter, r *http.Request) error {
 	hijacker := w.(http.Hijacker)
-	conn, err := net.Dial("tcp", ":8888")
+	conn, err := net.Dial("tcp", gateway.addr())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// default schema files of model data and result in resource dir
+const (
+	inputSchemaName  = "input.schema.json"
+	outputSchemaName = "output.schema.json"
+	maxSchemaErrors  = 10
+)
+
+// jsonSchema is subset of JSON Schema used to validate model data and results.
+// Supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// allOf, anyOf, oneOf and not. Schemas with other keywords except
+// annotations are rejected, so they never silently validate less.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	AllOf                []*jsonSchema          `json:"allOf"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	OneOf                []*jsonSchema          `json:"oneOf"`
+	Not                  *jsonSchema            `json:"not"`
+
+	// boolean is value of true or false schema
+	boolean  *bool
+	pattern  *regexp.Regexp
+	constant interface{}
+}
+
+// schemaKeywords are supported keywords and annotations ignored by validation
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true,
+	"additionalProperties": true, "items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true, "allOf": true, "anyOf": true,
+	"oneOf": true, "not": true,
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true,
+}
+
+// schemaTypes is type keyword, single type or list of types
+type schemaTypes []string
+
+func (st *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*st = schemaTypes{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(st))
+}
+
+func (s *jsonSchema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if json.Unmarshal(data, &b) == nil {
+		s.boolean = &b
+		return nil
+	}
+	var keywords map[string]json.RawMessage
+	err := json.Unmarshal(data, &keywords)
+	if err != nil {
+		return err
+	}
+	unsupported := []string{}
+	for keyword := range keywords {
+		if !schemaKeywords[keyword] {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return fmt.Errorf("unsupported schema keywords %s", strings.Join(unsupported, ", "))
+	}
+	type schemaAlias jsonSchema
+	err = json.Unmarshal(data, (*schemaAlias)(s))
+	if err != nil {
+		return err
+	}
+	if s.Pattern != "" {
+		s.pattern, err = regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", s.Pattern, err)
+		}
+	}
+	if len(s.Const) > 0 {
+		s.constant, err = decodeJSON(s.Const)
+		if err != nil {
+			return err
+		}
+	}
+	for i, v := range s.Enum {
+		s.Enum[i] = normalizeNumbers(v)
+	}
+	return nil
+}
+
+// loadSchema reads schema file from resource dir. Default
+// schema file is used if name is empty and it's optional.
+func loadSchema(dir, name, defaultName string) (*jsonSchema, error) {
+	path := name
+	if path == "" {
+		path = defaultName
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, path))
+	if name == "" && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &jsonSchema{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return s, nil
+}
+
+// decodeJSON decodes json keeping numbers as json.Number
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	err := decoder.Decode(&v)
+	return v, err
+}
+
+// normalizeNumbers converts float64 numbers decoded by json.Unmarshal to json.Number
+func normalizeNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case float64:
+		return json.Number(fmt.Sprint(value))
+	case []interface{}:
+		for i := range value {
+			value[i] = normalizeNumbers(value[i])
+		}
+	case map[string]interface{}:
+		for k := range value {
+			value[k] = normalizeNumbers(value[k])
+		}
+	}
+	return v
+}
+
+// Validate validates json document. Error lists violations
+// with JSON pointers to invalid values.
+func (s *jsonSchema) Validate(data []byte) error {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	errs := &schemaErrors{}
+	s.validate(v, "", errs)
+	if len(*errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// schemaErrors are schema violations
+type schemaErrors []string
+
+func (se *schemaErrors) add(ptr, format string, a ...interface{}) {
+	if len(*se) < maxSchemaErrors {
+		*se = append(*se, fmt.Sprintf("at %q: %s", ptr, fmt.Sprintf(format, a...)))
+	}
+}
+
+func (se *schemaErrors) Error() string {
+	return strings.Join(*se, "; ")
+}
+
+func (s *jsonSchema) validate(v interface{}, ptr string, errs *schemaErrors) {
+	if s.boolean != nil {
+		if !*s.boolean {
+			errs.add(ptr, "value is not allowed")
+		}
+		return
+	}
+	if len(s.Type) > 0 && !s.matchType(v) {
+		errs.add(ptr, "expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeOf(v))
+		return
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, v) {
+		errs.add(ptr, "value is not one of enum values")
+	}
+	if len(s.Const) > 0 && !equalValues(s.constant, v) {
+		errs.add(ptr, "value is not equal to %s", s.Const)
+	}
+	switch value := v.(type) {
+	case string:
+		s.validateString(value, ptr, errs)
+	case json.Number:
+		s.validateNumber(value, ptr, errs)
+	case []interface{}:
+		s.validateArray(value, ptr, errs)
+	case map[string]interface{}:
+		s.validateObject(value, ptr, errs)
+	}
+	for _, sub := range s.AllOf {
+		sub.validate(v, ptr, errs)
+	}
+	if len(s.AnyOf) > 0 && s.countValid(s.AnyOf, v) == 0 {
+		errs.add(ptr, "value does not match any of anyOf schemas")
+	}
+	if len(s.OneOf) > 0 {
+		if n := s.countValid(s.OneOf, v); n != 1 {
+			errs.add(ptr, "value matches %d of oneOf schemas instead of one", n)
+		}
+	}
+	if s.Not != nil && s.countValid([]*jsonSchema{s.Not}, v) == 1 {
+		errs.add(ptr, "value matches not schema")
+	}
+}
+
+func (s *jsonSchema) countValid(schemas []*jsonSchema, v interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		subErrs := &schemaErrors{}
+		sub.validate(v, "", subErrs)
+		if len(*subErrs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *jsonSchema) validateString(value, ptr string, errs *schemaErrors) {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		errs.add(ptr, "string is shorter than %d", *s.MinLength)
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		errs.add(ptr, "string is longer than %d", *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		errs.add(ptr, "string does not match pattern %q", s.Pattern)
+	}
+}
+
+func (s *jsonSchema) validateNumber(value json.Number, ptr string, errs *schemaErrors) {
+	n, _ := value.Float64()
+	if s.Minimum != nil && n < *s.Minimum {
+		errs.add(ptr, "%s is less than minimum %v", value, *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		errs.add(ptr, "%s is greater than maximum %v", value, *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		errs.add(ptr, "%s is not greater than %v", value, *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		errs.add(ptr, "%s is not less than %v", value, *s.ExclusiveMaximum)
+	}
+}
+
+func (s *jsonSchema) validateArray(value []interface{}, ptr string, errs *schemaErrors) {
+	if s.MinItems != nil && len(value) < *s.MinItems {
+		errs.add(ptr, "array has less than %d items", *s.MinItems)
+	}
+	if s.MaxItems != nil && len(value) > *s.MaxItems {
+		errs.add(ptr, "array has more than %d items", *s.MaxItems)
+	}
+	if s.Items != nil {
+		for i, item := range value {
+			s.Items.validate(item, fmt.Sprintf("%s/%d", ptr, i), errs)
+		}
+	}
+}
+
+func (s *jsonSchema) validateObject(value map[string]interface{}, ptr string, errs *schemaErrors) {
+	for _, name := range s.Required {
+		if _, ok := value[name]; !ok {
+			errs.add(ptr, "property %q is required", name)
+		}
+	}
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propPtr := ptr + "/" + escapePointer(name)
+		if prop, ok := s.Properties[name]; ok {
+			prop.validate(value[name], propPtr, errs)
+			continue
+		}
+		if s.AdditionalProperties != nil {
+			if b := s.AdditionalProperties.boolean; b != nil && !*b {
+				errs.add(ptr, "property %q is not allowed", name)
+				continue
+			}
+			s.AdditionalProperties.validate(value[name], propPtr, errs)
+		}
+	}
+}
+
+func (s *jsonSchema) matchType(v interface{}) bool {
+	actual := jsonTypeOf(v)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeOf returns JSON Schema type of decoded value
+func jsonTypeOf(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		n, err := value.Float64()
+		if err == nil && n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// escapePointer escapes JSON pointer reference token
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, value := range values {
+		if equalValues(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares decoded json values, numbers are compared by value
+func equalValues(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, _ := x.Float64()
+		fy, _ := y.Float64()
+		return fx == fy
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k := range x {
+			yv, ok := y[k]
+			if !ok || !equalValues(x[k], yv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
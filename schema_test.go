@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "features"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+		"kind": {"enum": ["setosa", "virginica", 1]},
+		"features": {
+			"type": "array",
+			"minItems": 2,
+			"items": {"type": "number", "minimum": 0, "exclusiveMaximum": 10}
+		},
+		"a/b": {"type": ["integer", "null"]},
+		"version": {"const": 2},
+		"extra": {"anyOf": [{"type": "string"}, {"type": "boolean"}]},
+		"one": {"oneOf": [{"type": "number"}, {"type": "integer"}]}
+	}
+}`
+
+func TestJSONSchema_Validate(t *testing.T) {
+	var s jsonSchema
+	err := json.Unmarshal([]byte(testSchema), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data   string
+		errors []string
+	}{
+		{`{"name": "iris", "features": [1, 2.5], "kind": 1.0, "version": 2.0, "a/b": null, "extra": true, "one": 1.5}`, nil},
+		{`[]`, []string{`at "": expected object, got array`}},
+		{`{"name": "iris"}`, []string{`at "": property "features" is required`}},
+		{`{"name": "Iris", "features": [1]}`, []string{
+			`at "/features": array has less than 2 items`,
+			`at "/name": string does not match pattern "^[a-z]+$"`,
+		}},
+		{`{"name": "iris", "features": [-1, "2", 10]}`, []string{
+			`at "/features/0": -1 is less than minimum 0`,
+			`at "/features/1": expected number, got string`,
+			`at "/features/2": 10 is not less than 10`,
+		}},
+		{`{"name": "iris", "features": [1, 2], "a/b": 1.5, "kind": "wine", "other": 1}`, []string{
+			`at "/a~1b": expected integer or null, got number`,
+			`at "/kind": value is not one of enum values`,
+			`at "": property "other" is not allowed`,
+		}},
+		{`{"name": "iris", "features": [1, 2], "version": 3, "extra": 1, "one": 1}`, []string{
+			`at "/extra": value does not match any of anyOf schemas`,
+			`at "/one": value matches 2 of oneOf schemas instead of one`,
+			`at "/version": value is not equal to 2`,
+		}},
+	}
+	for _, test := range tests {
+		err := s.Validate([]byte(test.data))
+		if len(test.errors) == 0 {
+			if err != nil {
+				t.Errorf("Valid data %s: %s", test.data, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("No error for invalid data %s", test.data)
+			continue
+		}
+		for _, e := range test.errors {
+			if !strings.Contains(err.Error(), e) {
+				t.Errorf("Error %q of %s doesn't contain %q", err, test.data, e)
+			}
+		}
+	}
+}
+
+func TestJSONSchema_MaxErrors(t *testing.T) {
+	var s jsonSchema
+	err := json.Unmarshal([]byte(`{"items": {"type": "string"}}`), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Validate([]byte(`[1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12]`))
+	if errs, ok := err.(*schemaErrors); !ok || len(*errs) != maxSchemaErrors {
+		t.Errorf("Wrong errors: %v", err)
+	}
+}
+
+func TestJSONSchema_UnsupportedKeywords(t *testing.T) {
+	var s jsonSchema
+	err := json.Unmarshal([]byte(`{"title": "iris", "properties": {"a": {"$ref": "#/definitions/a", "format": "date"}}}`), &s)
+	if err == nil || !strings.Contains(err.Error(), "$ref, format") {
+		t.Errorf("Wrong error for unsupported keywords: %v", err)
+	}
+}
+
+func TestJSONSchema_InvalidPattern(t *testing.T) {
+	var s jsonSchema
+	err := json.Unmarshal([]byte(`{"pattern": "("}`), &s)
+	if err == nil {
+		t.Error("No error on invalid pattern")
+	}
+}
+
+func TestEqualValues(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{`{"a": null}`, `{"a": null}`, true},
+		{`{"a": null}`, `{"b": null}`, false},
+		{`{"a": 1, "b": [1, {"c": 2}]}`, `{"b": [1.0, {"c": 2}], "a": 1.0}`, true},
+		{`[1, 2]`, `[2, 1]`, false},
+		{`"1"`, `1`, false},
+	}
+	for _, c := range cases {
+		a, _ := decodeJSON([]byte(c.a))
+		b, _ := decodeJSON([]byte(c.b))
+		if equalValues(a, b) != c.equal {
+			t.Errorf("Wrong comparison of %s and %s: %t", c.a, c.b, !c.equal)
+		}
+	}
+}
@@ -3,12 +3,22 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
 
 	"context"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
+)
+
+const (
+	// maxClockSkew is how far in the future request timestamp may be
+	maxClockSkew = time.Minute
+	// maxBatchSize is maximum number of items in batch request
+	maxBatchSize = 1000
 )
 
 var (
@@ -21,7 +31,10 @@ type requestError struct {
 	ModelVersionError  string   `json:"model_version_error,omitempty"`
 	TimestampError     string   `json:"timestamp_error,omitempty"`
 	DataError          string   `json:"data_error,omitempty"`
+	CallbackURLError   string   `json:"callback_url_error,omitempty"`
 	UnknownFields      []string `json:"unknown_fields,omitempty"`
+	// modelVersion is requested model version reported in error response
+	modelVersion string
 }
 
 func (re *requestError) Error() string {
@@ -33,12 +46,35 @@ func (re *requestError) Error() string {
 	return buf.String()
 }
 
+func (re *requestError) empty() bool {
+	return re.SchemaVersionError == "" &&
+		re.ModelVersionError == "" &&
+		re.TimestampError == "" &&
+		re.DataError == "" &&
+		re.CallbackURLError == "" &&
+		len(re.UnknownFields) == 0
+}
+
 // Request represents user request
 type Request struct {
 	SchemaVersion string          `json:"schema_version"`
 	ModelVersion  string          `json:"model_version"`
 	Timestamp     time.Time       `json:"timestamp"`
 	Data          json.RawMessage `json:"data"`
+	// CallbackURL is notified with response of async request
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// BatchRequest represents user request with several data items
+type BatchRequest struct {
+	Request
+	Items []*BatchItem `json:"items"`
+}
+
+// BatchItem is single data item of batch request
+type BatchItem struct {
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
 }
 
 // DataString encodes request data to string
@@ -46,12 +82,15 @@ func (r *Request) DataString() string {
 	return string(r.Data)
 }
 
-// AppError is main app error type
+// AppError is main app error type. Model version is requested or resolved
+// model version, version of request error is used if it's empty.
 type AppError struct {
-	Err        error
-	StatusCode int
-	Reason     string
-	Stacktrace string
+	Err          error
+	StatusCode   int
+	Reason       string
+	Stacktrace   string
+	Output       *Output
+	ModelVersion string
 }
 
 func (ae *AppError) Error() string {
@@ -66,16 +105,35 @@ func (ae *AppError) Error() string {
 
 // Write writes response to http.ResponseWriter with given context
 func (ae *AppError) Write(ctx context.Context, w http.ResponseWriter) {
+	resp := ae.Response()
+	w.WriteHeader(ae.StatusCode)
+	resp.Write(ctx, w)
+}
+
+// Response creates error response object
+func (ae *AppError) Response() *Response {
 	resp := &Response{
 		SchemaVersion: "0.1",
-		ModelVersion:  "1.0",
+		ModelVersion:  ae.ModelVersion,
 		Timestamp:     time.Now().UTC(),
 		Stacktrace:    ae.Stacktrace,
 		Status:        "error",
 		Reason:        ae.Error(),
+		Output:        ae.Output,
 	}
-	w.WriteHeader(ae.StatusCode)
-	resp.Write(ctx, w)
+	if re, ok := ae.Err.(*requestError); ok {
+		if ae.Reason == "" {
+			resp.Reason = "Request doesn't comply with schema"
+		}
+		resp.RequestError = re
+		if resp.ModelVersion == "" {
+			resp.ModelVersion = re.modelVersion
+		}
+	}
+	if ke, ok := ae.Err.(*kernelError); ok {
+		resp.setKernelError(ke)
+	}
+	return resp
 }
 
 // CreateResponseFromRequest creates response object from user request
@@ -96,7 +154,44 @@ type Response struct {
 	Data          json.RawMessage `json:"data,omitempty"`
 	Reason        string          `json:"reason,omitempty"`
 	Stacktrace    string          `json:"stacktrace,omitempty"`
-	err           *AppError
+	Ename         string          `json:"ename,omitempty"`
+	Evalue        string          `json:"evalue,omitempty"`
+	Traceback     []string        `json:"traceback,omitempty"`
+	RequestError  *requestError   `json:"request_error,omitempty"`
+	Items         []*ItemResponse `json:"items,omitempty"`
+	JobID         string          `json:"job_id,omitempty"`
+	*Output
+	err *AppError
+}
+
+// Output is stream output of script captured during execution
+type Output struct {
+	Stdout    string `json:"stdout,omitempty"`
+	Stderr    string `json:"stderr,omitempty"`
+	Truncated bool   `json:"output_truncated,omitempty"`
+}
+
+// ItemResponse is result of single batch request item
+type ItemResponse struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Reason     string          `json:"reason,omitempty"`
+	Stacktrace string          `json:"stacktrace,omitempty"`
+	Ename      string          `json:"ename,omitempty"`
+	Evalue     string          `json:"evalue,omitempty"`
+	Traceback  []string        `json:"traceback,omitempty"`
+	*Output
+}
+
+// setKernelError fills response with details of script exception
+func (sr *Response) setKernelError(ke *kernelError) {
+	sr.Ename = ke.Name
+	sr.Evalue = ke.Value
+	sr.Traceback = ke.Traceback
+	if sr.Stacktrace == "" {
+		sr.Stacktrace = ke.stacktrace()
+	}
 }
 
 // MarshalJSON implements custom json marshalling with logging
@@ -132,13 +227,160 @@ func (sr *Response) Write(ctx context.Context, w http.ResponseWriter) {
 
 // BuildRequest builds and validates user requests
 func BuildRequest(r io.Reader) (*Request, error) {
+	fields, err := decodeFields(r)
+	if err != nil {
+		return nil, err
+	}
 	var request Request
-	err := json.NewDecoder(r).Decode(&request)
+	reqErr := &requestError{}
+	for name, value := range fields {
+		if name == "data" {
+			request.Data = value
+			continue
+		}
+		request.setField(name, value, reqErr)
+	}
+	sort.Strings(reqErr.UnknownFields)
+	request.validate(reqErr)
+	if isEmptyData(request.Data) {
+		reqErr.DataError = "data is required"
+	}
+	if !reqErr.empty() {
+		reqErr.modelVersion = request.ModelVersion
+		return nil, reqErr
+	}
+	return &request, nil
+}
+
+// BuildBatchRequest builds and validates user batch requests
+func BuildBatchRequest(r io.Reader) (*BatchRequest, error) {
+	fields, err := decodeFields(r)
+	if err != nil {
+		return nil, err
+	}
+	var request BatchRequest
+	reqErr := &requestError{}
+	for name, value := range fields {
+		if name == "items" {
+			err = json.Unmarshal(value, &request.Items)
+			if err != nil {
+				reqErr.DataError = err.Error()
+			}
+			continue
+		}
+		request.setField(name, value, reqErr)
+	}
+	sort.Strings(reqErr.UnknownFields)
+	request.validate(reqErr)
+	if reqErr.DataError == "" {
+		reqErr.DataError = request.validateItems()
+	}
+	if !reqErr.empty() {
+		reqErr.modelVersion = request.ModelVersion
+		return nil, reqErr
+	}
+	return &request, nil
+}
+
+func decodeFields(r io.Reader) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&fields)
 	if err != nil {
 		if err == io.EOF {
 			return nil, errors.New("received empty request")
 		}
 		return nil, err
 	}
-	return &request, nil
+	return fields, nil
+}
+
+// setField decodes common request field, other fields are reported as unknown
+func (r *Request) setField(name string, value json.RawMessage, reqErr *requestError) {
+	var err error
+	switch name {
+	case "schema_version":
+		err = json.Unmarshal(value, &r.SchemaVersion)
+		if err != nil {
+			reqErr.SchemaVersionError = err.Error()
+		}
+	case "model_version":
+		err = json.Unmarshal(value, &r.ModelVersion)
+		if err != nil {
+			reqErr.ModelVersionError = err.Error()
+		}
+	case "timestamp":
+		err = json.Unmarshal(value, &r.Timestamp)
+		if err != nil {
+			reqErr.TimestampError = err.Error()
+		}
+	case "callback_url":
+		err = json.Unmarshal(value, &r.CallbackURL)
+		if err != nil {
+			reqErr.CallbackURLError = err.Error()
+		}
+	default:
+		reqErr.UnknownFields = append(reqErr.UnknownFields, name)
+	}
+}
+
+// validate fills request error with violations of request schema
+// which were not already reported during decoding
+func (r *Request) validate(reqErr *requestError) {
+	if reqErr.SchemaVersionError == "" {
+		if r.SchemaVersion == "" {
+			reqErr.SchemaVersionError = "schema_version is required"
+		} else if !schemaVersions[r.SchemaVersion] {
+			reqErr.SchemaVersionError = fmt.Sprintf("unknown schema version %q", r.SchemaVersion)
+		}
+	}
+	if reqErr.ModelVersionError == "" {
+		if r.ModelVersion == "" {
+			reqErr.ModelVersionError = "model_version is required"
+		} else if !modelVersions[r.ModelVersion] {
+			reqErr.ModelVersionError = fmt.Sprintf("unknown model version %q", r.ModelVersion)
+		}
+	}
+	if reqErr.TimestampError == "" {
+		if r.Timestamp.IsZero() {
+			reqErr.TimestampError = "timestamp is required"
+		} else if r.Timestamp.After(time.Now().UTC().Add(maxClockSkew)) {
+			reqErr.TimestampError = "timestamp is in the future"
+		}
+	}
+	if reqErr.CallbackURLError == "" && r.CallbackURL != "" {
+		u, err := url.Parse(r.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			reqErr.CallbackURLError = "callback_url must be absolute http or https url"
+		}
+	}
+}
+
+// validateItems returns description of first invalid batch item
+func (br *BatchRequest) validateItems() string {
+	if len(br.Items) == 0 {
+		return "items are required"
+	}
+	if len(br.Items) > maxBatchSize {
+		return fmt.Sprintf("batch contains %d items, maximum is %d", len(br.Items), maxBatchSize)
+	}
+	ids := map[string]bool{}
+	for i, item := range br.Items {
+		switch {
+		case item == nil:
+			return fmt.Sprintf("item %d is empty", i)
+		case item.ID == "":
+			return fmt.Sprintf("item %d: id is required", i)
+		case ids[item.ID]:
+			return fmt.Sprintf("item %d: duplicate id %q", i, item.ID)
+		case isEmptyData(item.Data):
+			return fmt.Sprintf("item %d: data is required", i)
+		}
+		ids[item.ID] = true
+	}
+	return ""
+}
+
+func isEmptyData(data json.RawMessage) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) == 0 || bytes.Equal(data, []byte("null"))
 }
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRequest_DataString(t *testing.T) {
@@ -20,168 +28,249 @@ func TestRequest_DataString(t *testing.T) {
 	}
 }
 
-//func TestRequest_UnmarshalJSON_WrongData(t *testing.T) {
-//	var buf bytes.Buffer
-//	buf.WriteString(`{"test": "test"}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("Wrong data")
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_NoSchemaVersion(t *testing.T) {
-//	var buf bytes.Buffer
-//	buf.WriteString(`{
-//		"schema_version": "",
-//		"model_version": "1.0",
-//		"timestamp": "2016-08-24T12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("No error when no schema is provided")
-//	} else {
-//		if e, ok := err.(*requestError); ok {
-//			if e.SchemaVersionError == "" {
-//				t.Error("Wrong error message")
-//			}
-//		} else {
-//			t.Error("Wrong error")
-//		}
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_WrongSchemaVersion(t *testing.T) {
-//	var buf bytes.Buffer
-//	buf.WriteString(`{
-//		"schema_version": "0.0",
-//		"model_version": "1.0",
-//		"timestamp": "2016-08-24T12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("No error when wrong schema is provided")
-//	} else {
-//		if e, ok := err.(*requestError); ok {
-//			if e.SchemaVersionError == "" {
-//				t.Error("Wrong error message")
-//			}
-//		} else {
-//			t.Error("Wrong error")
-//		}
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_NoModelVersion(t *testing.T) {
-//	var buf bytes.Buffer
-//	buf.WriteString(`{
-//		"schema_version": "0.1",
-//		"model_version": "",
-//		"timestamp": "2016-08-24T12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("No error when no model is provided")
-//	} else {
-//		if e, ok := err.(*requestError); ok {
-//			if e.ModelVersionError == "" {
-//				t.Error("Wrong error message")
-//			}
-//		} else {
-//			t.Error("Wrong error")
-//		}
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_WrongModelVersion(t *testing.T) {
-//	var buf bytes.Buffer
-//	buf.WriteString(`{
-//		"schema_version": "0.0",
-//		"model_version": "0.0",
-//		"timestamp": "2016-08-24T12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("No error when wrong model is provided")
-//	} else {
-//		if e, ok := err.(*requestError); ok {
-//			if e.SchemaVersionError == "" {
-//				t.Error("Wrong error message")
-//			}
-//		} else {
-//			t.Error("Wrong error")
-//		}
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_WrongTimestamp(t *testing.T) {
-//	var buf bytes.Buffer
-//
-//	buf.WriteString(`{
-//		"schema_version": "0.1",
-//		"model_version": "1.0",
-//		"timestamp": "2016-08-24 12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("No error when wrong timestamp is provided")
-//	} else {
-//		if e, ok := err.(*requestError); ok {
-//			if e.TimestampError == "" {
-//				t.Error("Wrong error message")
-//			}
-//		} else {
-//			t.Error("Wrong error")
-//		}
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_UndefinedFields(t *testing.T) {
-//	var buf bytes.Buffer
-//	buf.WriteString(`{
-//		"schema_version": "0.1",
-//		"model_version": "1.0",
-//		"timestampx": "2016-08-24T12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err == nil {
-//		t.Error("No error when undefined fields are provided")
-//	} else {
-//		if e, ok := err.(*requestError); ok {
-//			if len(e.UnknownFields) == 0 {
-//				t.Error("Wrong error message")
-//			}
-//		} else {
-//			t.Error("Wrong error")
-//		}
-//	}
-//}
-//
-//func TestRequest_UnmarshalJSON_WrongError(t *testing.T) {
-//	var buf bytes.Buffer
-//
-//	buf.WriteString(`{
-//		"schema_version": "0.1",
-//		"model_version": "1.0",
-//		"timestamp": "2016-08-24T12:35:25.391293168Z",
-//		"data": {"test": "test"}
-//	}`)
-//	err := getRequestError(&buf)
-//	if err != nil {
-//		t.Error("Error when good data is provided")
-//	}
-//}
-//
-//func getRequestError(r io.Reader) error {
-//	req := Request{}
-//	return json.NewDecoder(r).Decode(&req)
-//}
-//
+func TestBuildRequest_WrongData(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"test": "test"}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("Wrong data")
+	}
+}
+
+func TestBuildRequest_NoSchemaVersion(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "",
+		"model_version": "1.0",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when no schema is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.SchemaVersionError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_WrongSchemaVersion(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "0.0",
+		"model_version": "1.0",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when wrong schema is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.SchemaVersionError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_NoModelVersion(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "0.1",
+		"model_version": "",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when no model is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.ModelVersionError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_WrongModelVersion(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "0.0",
+		"model_version": "0.0",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when wrong model is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.SchemaVersionError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_WrongTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+
+	buf.WriteString(`{
+		"schema_version": "0.1",
+		"model_version": "1.0",
+		"timestamp": "2016-08-24 12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when wrong timestamp is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.TimestampError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_UndefinedFields(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "0.1",
+		"model_version": "1.0",
+		"timestampx": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when undefined fields are provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if len(e.UnknownFields) == 0 {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_WrongError(t *testing.T) {
+	var buf bytes.Buffer
+
+	buf.WriteString(`{
+		"schema_version": "0.1",
+		"model_version": "1.0",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`)
+	err := getRequestError(&buf)
+	if err != nil {
+		t.Error("Error when good data is provided")
+	}
+}
+
+func TestBuildRequest_FutureTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{
+		"schema_version": "0.1",
+		"model_version": "1.0",
+		"timestamp": "%s",
+		"data": {"test": "test"}
+	}`, time.Now().UTC().Add(time.Hour).Format(time.RFC3339Nano))
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when future timestamp is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.TimestampError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_NoData(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "0.1",
+		"model_version": "1.0",
+		"timestamp": "2016-08-24T12:35:25.391293168Z"
+	}`)
+	err := getRequestError(&buf)
+	if err == nil {
+		t.Error("No error when no data is provided")
+	} else {
+		if e, ok := err.(*requestError); ok {
+			if e.DataError == "" {
+				t.Error("Wrong error message")
+			}
+		} else {
+			t.Error("Wrong error")
+		}
+	}
+}
+
+func TestBuildRequest_WrongCallbackURL(t *testing.T) {
+	for _, callback := range []string{`"ftp://example.com/hook"`, `"/hook"`, `42`} {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, `{
+			"schema_version": "0.1",
+			"model_version": "1.0",
+			"timestamp": "2016-08-24T12:35:25.391293168Z",
+			"callback_url": %s,
+			"data": {"test": "test"}
+		}`, callback)
+		err := getRequestError(&buf)
+		if e, ok := err.(*requestError); !ok || e.CallbackURLError == "" {
+			t.Errorf("Wrong error for callback url %s: %v", callback, err)
+		}
+	}
+}
+
+func getRequestError(r io.Reader) error {
+	_, err := BuildRequest(r)
+	return err
+}
+
+func TestAppError_Write_RequestError(t *testing.T) {
+	ctx := context.Background()
+	w := httptest.NewRecorder()
+	ae := AppError{
+		Err:        &requestError{UnknownFields: []string{"timestampx"}},
+		StatusCode: http.StatusBadRequest,
+	}
+	ae.Write(ctx, w)
+	if w.Code != http.StatusBadRequest {
+		t.Error("Wrong status code")
+	}
+	resp := Response{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Error(err)
+	}
+	if resp.RequestError == nil || len(resp.RequestError.UnknownFields) != 1 {
+		t.Error("Request error is not included in response")
+	}
+}
+
 //func TestAppError_Error(t *testing.T) {
 //	errorMsg := "test"
 //	ae := AppError{Reason: errorMsg}
@@ -309,3 +398,63 @@ func TestRequest_DataString(t *testing.T) {
 //		t.Error("Wrong status")
 //	}
 //}
+
+func TestBuildBatchRequest(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`{
+		"schema_version": "0.1",
+		"model_version": "1.0",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"items": [
+			{"id": "a", "data": {"test": 1}},
+			{"id": "b", "data": {"test": 2}}
+		]
+	}`)
+	req, err := BuildBatchRequest(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Items) != 2 || req.Items[1].ID != "b" {
+		t.Errorf("Wrong batch items: %+v", req.Items)
+	}
+}
+
+func TestBuildBatchRequest_BadItems(t *testing.T) {
+	bodies := []string{
+		`"items": []`,
+		`"items": [{"data": {"test": 1}}]`,
+		`"items": [{"id": "a", "data": {"test": 1}}, {"id": "a", "data": {"test": 2}}]`,
+		`"items": [{"id": "a"}]`,
+		`"items": {"id": "a"}`,
+	}
+	for _, body := range bodies {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, `{
+			"schema_version": "0.1",
+			"model_version": "1.0",
+			"timestamp": "2016-08-24T12:35:25.391293168Z",
+			%s
+		}`, body)
+		_, err := BuildBatchRequest(&buf)
+		if e, ok := err.(*requestError); !ok || e.DataError == "" {
+			t.Errorf("No data error for batch items %s: %v", body, err)
+		}
+	}
+}
+
+func TestAppError_ModelVersion(t *testing.T) {
+	err := getRequestError(strings.NewReader(`{
+		"schema_version": "0.1",
+		"model_version": "9.9",
+		"timestamp": "2016-08-24T12:35:25.391293168Z",
+		"data": {"test": "test"}
+	}`))
+	appErr := AppError{Err: err, StatusCode: http.StatusBadRequest}
+	if v := appErr.Response().ModelVersion; v != "9.9" {
+		t.Errorf("Wrong model version of request error: %q", v)
+	}
+	appErr = AppError{Err: errModelNotFound, StatusCode: http.StatusNotFound, ModelVersion: "2.0"}
+	if v := appErr.Response().ModelVersion; v != "2.0" {
+		t.Errorf("Wrong model version of error: %q", v)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// streamSuffix is path suffix of streaming requests
+const streamSuffix = "/stream"
+
+// streaming response types
+const (
+	sseType    = "text/event-stream"
+	ndjsonType = "application/x-ndjson"
+)
+
+var errStreamingUnsupported = errors.New("Streaming is not supported")
+
+type messagesKey struct{}
+
+// withMessages returns context passing every message
+// received by execution of code to fn
+func withMessages(ctx context.Context, fn func(*msg)) context.Context {
+	return context.WithValue(ctx, messagesKey{}, fn)
+}
+
+func messageListener(ctx context.Context) func(*msg) {
+	fn, _ := ctx.Value(messagesKey{}).(func(*msg))
+	return fn
+}
+
+// streamWriter writes events as server-sent events or json lines
+type streamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+}
+
+// newStreamWriter creates stream writer for Accept header,
+// server-sent events are written only if they are accepted
+func newStreamWriter(w http.ResponseWriter, accept string) (*streamWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errStreamingUnsupported
+	}
+	sw := &streamWriter{w: w, flusher: flusher}
+	for _, t := range parseAccept(accept) {
+		if t == sseType {
+			sw.sse = true
+			break
+		}
+	}
+	return sw, nil
+}
+
+// start writes response headers
+func (sw *streamWriter) start() {
+	contentType := ndjsonType
+	if sw.sse {
+		contentType = sseType
+	}
+	sw.w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	sw.w.Header().Set("Cache-Control", "no-cache")
+	sw.w.Header().Set("X-Accel-Buffering", "no")
+	sw.w.Header().Set("X-Content-Type-Options", "nosniff")
+	sw.w.WriteHeader(http.StatusOK)
+	sw.flusher.Flush()
+}
+
+// write writes single event with json payload and flushes it
+func (sw *streamWriter) write(event string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s event: %s", event, err)
+		return
+	}
+	if sw.sse {
+		_, err = fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", event, data)
+	} else {
+		err = json.NewEncoder(sw.w).Encode(&struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}{event, data})
+	}
+	if err != nil {
+		log.Printf("Error writing %s event: %s", event, err)
+		return
+	}
+	sw.flusher.Flush()
+}
+
+// message writes kernel output message as event
+func (sw *streamWriter) message(m *msg) {
+	switch m.Header.MsgType {
+	case "stream":
+		sw.write("stream", map[string]interface{}{
+			"name": m.Content["name"],
+			"text": m.Content["text"],
+		})
+	case "display_data", "execute_result":
+		sw.write(m.Header.MsgType, m.Content["data"])
+	}
+}
+
+// StreamHandler runs model function and streams its output, displayed data
+// and result as they arrive. Stream is terminated by response event.
+func StreamHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRequest(context.Background(), w, r) {
+		return
+	}
+	ctx, cancel, ok := requestContext(w, r)
+	defer cancel()
+	if !ok {
+		return
+	}
+	requestData, err := BuildRequest(r.Body)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusBadRequest}
+		appErr.Write(ctx, w)
+		return
+	}
+	if !checkCallback(ctx, w, requestData, false) {
+		return
+	}
+	m, err := routeModel(strings.TrimSuffix(r.URL.Path, streamSuffix), requestData)
+	if err != nil {
+		writeRouteError(ctx, w, err, requestData)
+		return
+	}
+	if !checkData(ctx, w, m, requestData.Data) {
+		return
+	}
+	code, err := functionCall(m.Function, requestData.Data)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError, ModelVersion: m.Version}
+		appErr.Write(ctx, w)
+		return
+	}
+	sw, err := newStreamWriter(w, r.Header.Get("Accept"))
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError, ModelVersion: m.Version}
+		appErr.Write(ctx, w)
+		return
+	}
+	sw.start()
+	ctx = withMessages(ctx, sw.message)
+	resp := runResponse(ctx, m, code, requestData, false)
+	resp.Timestamp = time.Now().UTC()
+	sw.write("response", resp)
+}
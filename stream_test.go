@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func streamKernel() (*httptest.Server, *session) {
+	return mockKernel(func(ws *websocket.Conn, req *msg) {
+		websocket.JSON.Send(ws, replyMsg(req, "stream", map[string]interface{}{"name": "stdout", "text": "epoch 1\n"}))
+		websocket.JSON.Send(ws, replyMsg(req, "display_data", map[string]interface{}{
+			"data": map[string]interface{}{"text/plain": "<Figure>"},
+		}))
+		websocket.JSON.Send(ws, executeResult(req, "'done'"))
+		finish(ws, req)
+	})
+}
+
+func TestStreamWriter_NDJSON(t *testing.T) {
+	ts, s := streamKernel()
+	defer ts.Close()
+	defer s.Close()
+	w := httptest.NewRecorder()
+	sw, err := newStreamWriter(w, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw.start()
+	_, err = s.execute(withMessages(context.Background(), sw.message), "test()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw.write("response", &Response{Status: "ok"})
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, ndjsonType) {
+		t.Errorf("Wrong content type: %s", ct)
+	}
+	var events []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var event struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}
+		err = json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatalf("Invalid json line %s: %s", scanner.Text(), err)
+		}
+		events = append(events, event.Event)
+	}
+	expected := "stream,display_data,execute_result,response"
+	if strings.Join(events, ",") != expected {
+		t.Errorf("Wrong events\nExpected: %s\nActual: %s\n", expected, strings.Join(events, ","))
+	}
+}
+
+func TestStreamWriter_SSE(t *testing.T) {
+	ts, s := streamKernel()
+	defer ts.Close()
+	defer s.Close()
+	w := httptest.NewRecorder()
+	sw, err := newStreamWriter(w, "text/event-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw.start()
+	_, err = s.execute(withMessages(context.Background(), sw.message), "test()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, sseType) {
+		t.Errorf("Wrong content type: %s", ct)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "event: stream\ndata: {\"name\":\"stdout\",\"text\":\"epoch 1\\n\"}\n\n") {
+		t.Errorf("Wrong server-sent events:\n%s", body)
+	}
+	if !strings.Contains(body, "event: execute_result\ndata: {\"text/plain\":\"'done'\"}\n\n") {
+		t.Errorf("Result event is missing:\n%s", body)
+	}
+}
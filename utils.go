@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -31,6 +30,38 @@ type Args struct {
 	Script      string
 	Function    string
 	SecretKey   string
+
+	Kernels           int
+	BatchConcurrency  int
+	RequestTimeout    time.Duration
+	MaxRequestTimeout time.Duration
+	MaxOutput         int
+	InputErrors       string
+
+	GatewayHost     string
+	GatewayPort     int
+	GatewayArgs     string
+	ExternalGateway bool
+
+	CallTemplate string
+
+	Schedule string
+	Overlap  string
+
+	MaxAttempts    int
+	RetryBackoff   time.Duration
+	RetryErrors    string
+	FailureWebhook string
+	RunTimeout     time.Duration
+	ResultFile     string
+
+	JobRetention time.Duration
+	CallbackURL  string
+
+	IdempotencyTTL time.Duration
+	CacheSize      int
+	CacheTTL       time.Duration
+	ValidateOutput bool
 }
 
 type APIClient struct {
@@ -51,11 +82,6 @@ func CreateAuthInfo(token string) runtime.ClientAuthInfoWriterFunc {
 	})
 }
 
-func validateJSON(s []byte) bool {
-	var js map[string]interface{}
-	return json.Unmarshal(s, &js) == nil
-}
-
 func checkToken(apiRoot, token string) bool {
 	if token == "" {
 		return false
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// signatureHeader is hex HMAC-SHA256 of webhook body keyed with secret key
+	signatureHeader = "X-Runner-Signature"
+	webhookAttempts = 5
+)
+
+var (
+	webhookClient  = &http.Client{Timeout: 10 * time.Second}
+	webhookBackoff = time.Second
+)
+
+// runEvent is webhook payload describing function run
+type runEvent struct {
+	Event     string     `json:"event"`
+	Namespace string     `json:"namespace"`
+	ProjectID string     `json:"project_id"`
+	ServerID  string     `json:"server_id"`
+	Script    string     `json:"script"`
+	Function  string     `json:"function"`
+	Run       *runRecord `json:"run"`
+}
+
+func newRunEvent(event string, rec *runRecord) *runEvent {
+	return &runEvent{
+		Event:     event,
+		Namespace: args.Namespace,
+		ProjectID: args.ProjectID,
+		ServerID:  args.ServerID,
+		Script:    args.Script,
+		Function:  args.Function,
+		Run:       rec,
+	}
+}
+
+// signature returns signature of webhook body, receivers compute
+// HMAC-SHA256 of body with shared secret key to verify it
+func signature(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(args.SecretKey))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook posts payload as json to url. Delivery is retried
+// with exponential backoff on network errors and server errors.
+func postWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := sendWebhook(url, body)
+		if err == nil || !retry || attempt >= webhookAttempts {
+			return err
+		}
+		log.Printf("%s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendWebhook sends webhook once and reports if failed delivery could be retried
+func sendWebhook(url string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if args.SecretKey != "" {
+		req.Header.Set(signatureHeader, signature(body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		retry := resp.StatusCode >= http.StatusInternalServerError ||
+			resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("Webhook %s responded with %s", url, resp.Status)
+	}
+	return false, nil
+}
+
+// notifyFailure posts run failed after all attempts to args.FailureWebhook
+func notifyFailure(rec *runRecord) {
+	if args.FailureWebhook == "" {
+		return
+	}
+	err := postWebhook(args.FailureWebhook, newRunEvent("run_failed", rec))
+	if err != nil {
+		log.Printf("Error notifying about failed run: %s", err)
+	}
+}
+
+// notifyFinished posts response of finished run to args.CallbackURL,
+// response has the same shape as restful server response
+func notifyFinished(rec *runRecord) {
+	if args.CallbackURL == "" {
+		return
+	}
+	err := postWebhook(args.CallbackURL, recordResponse(rec))
+	if err != nil {
+		log.Printf("Error notifying about finished run: %s", err)
+	}
+}
+
+// recordResponse creates response with result or error of run
+func recordResponse(rec *runRecord) *Response {
+	if rec.err != nil {
+		appErr := runError(rec.err, nil, false)
+		appErr.Output = rec.Output
+		resp := appErr.Response()
+		resp.Timestamp = rec.End
+		return resp
+	}
+	data, err := jsonOutput(rec.bundle)
+	if err != nil {
+		appErr := AppError{Err: err, StatusCode: http.StatusInternalServerError, Output: rec.Output}
+		resp := appErr.Response()
+		resp.Timestamp = rec.End
+		return resp
+	}
+	return &Response{
+		SchemaVersion: "0.1",
+		Timestamp:     rec.End,
+		Status:        "ok",
+		ExecutionTime: rec.Duration,
+		Data:          data,
+		Output:        rec.Output,
+	}
+}
+
+// notifyCallback posts final response of async request to its callback url
+func notifyCallback(url string, resp *Response) {
+	resp.Timestamp = time.Now().UTC()
+	err := postWebhook(url, resp)
+	if err != nil {
+		log.Printf("Error notifying callback of job %s: %s", resp.JobID, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPostWebhook_Signed(t *testing.T) {
+	defer func(secret string) { args.SecretKey = secret }(args.SecretKey)
+	args.SecretKey = "secret"
+	signatures := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		signatures <- hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(expected))
+	}))
+	defer ts.Close()
+	err := postWebhook(ts.URL, &Response{Status: "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !<-signatures {
+		t.Error("Wrong webhook signature")
+	}
+}
+
+func TestPostWebhook_Retries(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+	cases := []struct {
+		failures int
+		status   int
+		attempts int
+		failed   bool
+	}{
+		{2, http.StatusServiceUnavailable, 3, false},
+		{2, http.StatusTooManyRequests, 3, false},
+		{10, http.StatusInternalServerError, webhookAttempts, true},
+		{10, http.StatusBadRequest, 1, true},
+	}
+	for _, c := range cases {
+		attempts := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts <= c.failures {
+				w.WriteHeader(c.status)
+			}
+		}))
+		err := postWebhook(ts.URL, &Response{Status: "ok"})
+		ts.Close()
+		if (err != nil) != c.failed {
+			t.Errorf("%d: wrong delivery error: %v", c.status, err)
+		}
+		if attempts != c.attempts {
+			t.Errorf("%d: wrong number of attempts: %d", c.status, attempts)
+		}
+	}
+}